@@ -0,0 +1,139 @@
+package zmachine
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+)
+
+var ErrNotWaitingForCommand = errors.New("zmachine: not waiting for a command")
+
+// What trying a command on a copy of the machine did
+type ActionResult struct {
+	Command string
+	Output  string
+	// The command changed the object tree, attributes, properties or
+	// world globals: the game understood it. Commands the parser
+	// rejects change nothing but its scratch globals.
+	Valid bool
+	// Objects whose attributes, parent, sibling, child or properties
+	// changed
+	Objects []uint16
+	// World globals of the active profile that changed
+	Globals []uint8
+	// Set if the game crashed or went over its limits
+	Err error
+}
+
+// TryActions plays each command on a clone of the machine, which must be
+// waiting for a line, and compares the world state before and after.
+// The machine itself is left untouched. Only the globals the active
+// profile names count, less its moves global. Clones have no storage for
+// saved games, their output is captured and they run under DefaultLimits
+// if the machine has no limits of its own.
+func (zm *ZMachine) TryActions(commands []string) (results []ActionResult, err error) {
+	defer zm.recoverError(&err)
+	if zm.waiting.Kind != INPUT_LINE {
+		return nil, ErrNotWaitingForCommand
+	}
+
+	results = make([]ActionResult, len(commands))
+	for i, command := range commands {
+		var output bytes.Buffer
+		clone := zm.Clone()
+		clone.SetOutput(&output)
+		clone.Storage = nil
+		clone.Warnings = ioutil.Discard
+		if clone.Limits == (Limits{}) {
+			clone.Limits = DefaultLimits()
+		}
+
+		err := clone.SendLine(command)
+		if err == nil {
+			_, err = clone.RunUntilInput()
+		}
+
+		objects, globals := zm.diffWorld(clone, zm.ActiveProfile())
+		results[i] = ActionResult{
+			Command: command,
+			Output:  output.String(),
+			Valid:   len(objects) > 0 || len(globals) > 0,
+			Objects: objects,
+			Globals: globals,
+			Err:     err,
+		}
+	}
+	return results, nil
+}
+
+// ValidActions returns the commands that change the world state.
+func (zm *ZMachine) ValidActions(commands []string) ([]string, error) {
+	results, err := zm.TryActions(commands)
+	if err != nil {
+		return nil, err
+	}
+	var valid []string
+	for _, r := range results {
+		if r.Valid {
+			valid = append(valid, r.Command)
+		}
+	}
+	return valid, nil
+}
+
+// Objects and world globals of p that differ in other, a clone of zm
+func (zm *ZMachine) diffWorld(other *ZMachine, p GameProfile) ([]uint16, []uint8) {
+	var objects []uint16
+	var globals []uint8
+
+	numObjects := zm.NumObjects()
+	for obj := uint16(1); obj <= numObjects; obj++ {
+		if zm.objectChanged(other, obj) {
+			objects = append(objects, obj)
+		}
+	}
+
+	for _, v := range worldGlobals(p) {
+		if zm.ReadGlobal(v) != other.ReadGlobal(v) {
+			globals = append(globals, v)
+		}
+	}
+	return objects, globals
+}
+
+// Attributes, tree links and property values, not the property table
+// address
+func (zm *ZMachine) objectChanged(other *ZMachine, obj uint16) bool {
+	addr := zm.GetObjectEntryAddress(obj)
+	for i := uint32(0); i < 7; i++ {
+		if zm.GetUint8(addr+i) != other.GetUint8(addr+i) {
+			return true
+		}
+	}
+
+	// Not wrapping around, a list without an end runs out of memory
+	prop := uint32(zm.GetFirstPropertyAddress(obj))
+	for size := zm.LoadByte(prop); size != 0; size = zm.LoadByte(prop) {
+		end := prop + 1 + uint32(size>>5) + 1
+		for prop++; prop < end; prop++ {
+			if zm.LoadByte(prop) != other.LoadByte(prop) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Location, score, player and world globals of p, less its moves global
+func worldGlobals(p GameProfile) []uint8 {
+	candidates := append([]uint8{p.LocationGlobal, p.ScoreGlobal, p.PlayerGlobal}, p.WorldGlobals...)
+	var list []uint8
+	seen := map[uint8]bool{p.MovesGlobal: true}
+	for _, v := range candidates {
+		if v >= 0x10 && !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
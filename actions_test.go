@@ -0,0 +1,59 @@
+package zmachine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidActions(t *testing.T) {
+	zm := miniMachine(t)
+	before := zm.StateHash()
+
+	// Each sets the parser's verb global, only some change the world
+	commands := []string{"xyzzy", "frobnicate lamp", "look", "take lamp", "north", "save"}
+	results, err := zm.TryActions(commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var valid []string
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Command, r.Err)
+		}
+		if r.Valid {
+			valid = append(valid, r.Command)
+		}
+	}
+	if want := []string{"take lamp", "north"}; !reflect.DeepEqual(valid, want) {
+		t.Errorf("valid %q, want %q", valid, want)
+	}
+	if take := results[3]; !reflect.DeepEqual(take.Objects, []uint16{3, 4}) || !reflect.DeepEqual(take.Globals, []uint8{0x11}) {
+		t.Errorf("take lamp changed objects %v and globals %v", take.Objects, take.Globals)
+	}
+	if zm.StateHash() != before {
+		t.Error("trying commands changed the machine")
+	}
+
+	// Properties count too
+	zm.Profile = &GameProfile{}
+	clone := zm.Clone()
+	clone.SetObjectProperty(4, 5, 8)
+	if objects, globals := zm.diffWorld(clone, *zm.Profile); !reflect.DeepEqual(objects, []uint16{4}) || globals != nil {
+		t.Errorf("put_prop changed objects %v and globals %v", objects, globals)
+	}
+}
+
+func TestTryActionsLimits(t *testing.T) {
+	zm := miniMachine(t)
+	zm.Limits = Limits{Instructions: 5}
+	results, err := zm.TryActions([]string{"take lamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != ErrInstructionLimit {
+		t.Errorf("error %v, want %v", results[0].Err, ErrInstructionLimit)
+	}
+	if zm.Limits != (Limits{Instructions: 5}) {
+		t.Errorf("limits changed to %+v", zm.Limits)
+	}
+}
@@ -0,0 +1,198 @@
+// Package bench plays scripted walkthroughs to measure the interpreter:
+// instructions per second and allocations per turn for each execution
+// mode, and a profile of the opcodes and routines run. The same
+// walkthroughs are Go benchmarks, go test -bench=. ./bench, and a test
+// that every mode plays them the same.
+//
+// The stories directory holds small test stories, zasm sources
+// assembled when loaded, each with a walkthrough (see package
+// walkthrough) and its golden transcript (see package golden).
+// RESULTS.txt compares the modes on them.
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/awgh/zmachine"
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+// A short tour of the first rooms of Zork I, for story files without a
+// walkthrough
+var ZORK1_TOUR = []string{
+	"look", "open mailbox", "take leaflet", "read leaflet", "drop leaflet",
+	"south", "east", "open window", "enter", "take all", "west", "take lamp",
+	"move rug", "open trap door", "turn on lamp", "down", "north", "inventory",
+	"score",
+}
+
+type Story struct {
+	Name     string
+	Data     []byte
+	Commands []string
+}
+
+// How the machine runs instructions
+type Mode struct {
+	Name            string
+	DisableCache    bool
+	CompileRoutines bool
+}
+
+var Modes = []Mode{
+	{Name: "interpreter", DisableCache: true},
+	{Name: "cached"},
+	{Name: "compiled", CompileRoutines: true},
+}
+
+type Result struct {
+	Story string
+	Mode  string
+	// Playthroughs, and commands played in all of them
+	Runs  int
+	Turns int
+	// Totals over all runs
+	Instructions uint64
+	Duration     time.Duration
+	Allocs       uint64
+	Bytes        uint64
+}
+
+func (r Result) InstructionsPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Instructions) / r.Duration.Seconds()
+}
+
+func (r Result) AllocsPerTurn() float64 {
+	if r.Turns == 0 {
+		return 0
+	}
+	return float64(r.Allocs) / float64(r.Turns)
+}
+
+func (r Result) String() string {
+	var bytesPerTurn uint64
+	if r.Turns > 0 {
+		bytesPerTurn = r.Bytes / uint64(r.Turns)
+	}
+	var perRun time.Duration
+	if r.Runs > 0 {
+		perRun = r.Duration / time.Duration(r.Runs)
+	}
+	return fmt.Sprintf("%-12s %-12s %12.0f instr/s %8.1f allocs/turn %8d B/turn %12v/run",
+		r.Story, r.Mode, r.InstructionsPerSecond(), r.AllocsPerTurn(), bytesPerTurn, perRun)
+}
+
+// LoadStories reads the stories of dir that have a walkthrough.
+func LoadStories(dir string) ([]Story, error) {
+	walkthroughs, err := walkthrough.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var stories []Story
+	for _, w := range walkthroughs {
+		data, err := walkthrough.ReadStory(w.Story)
+		if err != nil {
+			return nil, err
+		}
+		commands, err := walkthrough.ReadCommands(w.Commands)
+		if err != nil {
+			return nil, err
+		}
+		stories = append(stories, Story{Name: w.Name, Data: data, Commands: commands})
+	}
+	return stories, nil
+}
+
+// The story loaded to run in mode
+func load(story Story, mode Mode) (*zmachine.ZMachine, error) {
+	game, err := zmachine.LoadBytes(story.Data)
+	if err != nil {
+		return nil, err
+	}
+	game.DisableCache = mode.DisableCache
+	game.CompileRoutines = mode.CompileRoutines
+	return game, nil
+}
+
+// Run plays the walkthrough runs times in the given mode.
+func Run(story Story, mode Mode, runs int) (Result, error) {
+	game, err := load(story, mode)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Story: story.Name, Mode: mode.Name, Runs: runs}
+	// Decode and compile before measuring
+	if _, _, err := play(game, story.Commands); err != nil {
+		return result, err
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		turns, instructions, err := play(game, story.Commands)
+		if err != nil {
+			return result, err
+		}
+		result.Turns += turns
+		result.Instructions += instructions
+	}
+	result.Duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.Allocs = after.Mallocs - before.Mallocs
+	result.Bytes = after.TotalAlloc - before.TotalAlloc
+	return result, nil
+}
+
+// Profile plays the walkthrough once with a profiler.
+func Profile(story Story) (*zmachine.Profiler, error) {
+	zm, err := zmachine.LoadBytes(story.Data)
+	if err != nil {
+		return nil, err
+	}
+	zm.Profiler = zmachine.NewProfiler()
+	_, _, err = play(zm, story.Commands)
+	return zm.Profiler, err
+}
+
+// Plays the commands on a copy of the game, which shares its decoded
+// and compiled instructions and its profiler. Returns the turns played
+// and instructions run.
+func play(game *zmachine.ZMachine, commands []string) (int, uint64, error) {
+	zm := game.Clone()
+	zm.Profiler = game.Profiler
+	var output bytes.Buffer
+	zm.SetOutput(&output)
+
+	turns := 0
+	for _, command := range commands {
+		if _, err := zm.RunUntilInput(); err != nil {
+			return turns, zm.Instructions(), err
+		}
+		if zm.Done {
+			return turns, zm.Instructions(), nil
+		}
+		var err error
+		if zm.Waiting().Kind == zmachine.INPUT_CHAR {
+			err = zm.SendKey(13)
+		} else {
+			err = zm.SendLine(command)
+		}
+		if err != nil {
+			return turns, zm.Instructions(), err
+		}
+		turns++
+		output.Reset()
+	}
+	_, err := zm.RunUntilInput()
+	return turns, zm.Instructions(), err
+}
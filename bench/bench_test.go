@@ -0,0 +1,160 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awgh/zmachine"
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+func testStories(tb testing.TB) []Story {
+	tb.Helper()
+	stories, err := LoadStories("stories")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(stories) == 0 {
+		tb.Fatal("no stories with walkthroughs in stories")
+	}
+	return stories
+}
+
+// go test -bench=. ./bench, instr/s is reported beside ns/op
+func BenchmarkWalkthrough(b *testing.B) {
+	for _, story := range testStories(b) {
+		story := story
+		b.Run(story.Name, func(b *testing.B) {
+			for _, mode := range Modes {
+				mode := mode
+				b.Run(mode.Name, func(b *testing.B) {
+					benchmarkWalkthrough(b, story, mode)
+				})
+			}
+		})
+	}
+}
+
+// A real game: ZMACHINE_BENCH_STORY=zork1.dat go test -bench=Story ./bench
+// plays ZORK1_TOUR, or the walkthrough in ZMACHINE_BENCH_COMMANDS
+func BenchmarkStory(b *testing.B) {
+	path := os.Getenv("ZMACHINE_BENCH_STORY")
+	if path == "" {
+		b.Skip("ZMACHINE_BENCH_STORY not set")
+	}
+	data, err := walkthrough.ReadStory(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	commands := ZORK1_TOUR
+	if commandsPath := os.Getenv("ZMACHINE_BENCH_COMMANDS"); commandsPath != "" {
+		if commands, err = walkthrough.ReadCommands(commandsPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+	story := Story{Name: filepath.Base(path), Data: data, Commands: commands}
+	for _, mode := range Modes {
+		mode := mode
+		b.Run(mode.Name, func(b *testing.B) {
+			benchmarkWalkthrough(b, story, mode)
+		})
+	}
+}
+
+func benchmarkWalkthrough(b *testing.B, story Story, mode Mode) {
+	game, err := load(story, mode)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Decode and compile before measuring
+	if _, _, err := play(game, story.Commands); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	var instructions uint64
+	for i := 0; i < b.N; i++ {
+		_, n, err := play(game, story.Commands)
+		if err != nil {
+			b.Fatal(err)
+		}
+		instructions += n
+	}
+	b.ReportMetric(float64(instructions)/time.Since(start).Seconds(), "instr/s")
+}
+
+// The whole output of the walkthrough
+func transcript(t *testing.T, story Story, mode Mode) string {
+	zm, err := load(story, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	zm.SetOutput(&output)
+	for _, command := range story.Commands {
+		if _, err := zm.RunUntilInput(); err != nil {
+			t.Fatalf("%s: %v", mode.Name, err)
+		}
+		if zm.Done {
+			break
+		}
+		if zm.Waiting().Kind == zmachine.INPUT_CHAR {
+			err = zm.SendKey(13)
+		} else {
+			err = zm.SendLine(command)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", mode.Name, err)
+		}
+	}
+	if _, err := zm.RunUntilInput(); err != nil {
+		t.Fatalf("%s: %v", mode.Name, err)
+	}
+	return output.String()
+}
+
+// The cache and compiled routines behave as the plain interpreter does
+func TestModesAgree(t *testing.T) {
+	for _, story := range testStories(t) {
+		story := story
+		t.Run(story.Name, func(t *testing.T) {
+			want := transcript(t, story, Modes[0])
+			for _, mode := range Modes[1:] {
+				if got := transcript(t, story, mode); got != want {
+					t.Errorf("%s output differs from %s:\n%s\nwant:\n%s", mode.Name, Modes[0].Name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, story := range testStories(t) {
+		for _, mode := range Modes {
+			r, err := Run(story, mode, 2)
+			if err != nil {
+				t.Fatalf("%s %s: %v", story.Name, mode.Name, err)
+			}
+			if r.Runs != 2 || r.Turns != 2*len(story.Commands) || r.Instructions == 0 {
+				t.Errorf("%s %s: %+v", story.Name, mode.Name, r)
+			}
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	for _, story := range testStories(t) {
+		p, err := Profile(story)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Instructions == 0 || len(p.Routines) == 0 || len(p.OpcodeCounts()) == 0 {
+			t.Errorf("%s: empty profile", story.Name)
+		}
+	}
+}
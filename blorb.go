@@ -0,0 +1,153 @@
+package zmachine
+
+// Blorb resource containers, see http://www.eblong.com/zarf/blorb/
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	BLORB_USAGE_PICTURE = "Pict"
+	BLORB_USAGE_SOUND   = "Snd "
+	BLORB_USAGE_EXEC    = "Exec"
+	BLORB_USAGE_DATA    = "Data"
+)
+
+// A resource listed in the Blorb resource index
+type BlorbResource struct {
+	Usage  string
+	Number uint32
+	// Chunk type: "ZCOD", "PNG ", "JPEG", "OGGV", "AIFF"...
+	Type string
+	// Chunk contents. AIFF sounds keep their FORM header.
+	Data []byte
+}
+
+type Blorb struct {
+	Resources []BlorbResource
+	// iFiction XML from the IFmd chunk, if any
+	Metadata string
+	// Picture number of the cover art (Fspc chunk), if HasFrontispiece
+	Frontispiece    uint32
+	HasFrontispiece bool
+}
+
+// True if buf starts like a Blorb file
+func IsBlorb(buf []byte) bool {
+	return len(buf) >= 12 && string(buf[0:4]) == "FORM" && string(buf[8:12]) == "IFRS"
+}
+
+// Returns chunk type, data and offset of the next chunk
+func readChunk(buf []byte, offset uint32) (string, []byte, uint32, error) {
+	if uint64(offset)+8 > uint64(len(buf)) {
+		return "", nil, 0, fmt.Errorf("blorb chunk at 0x%X truncated", offset)
+	}
+	chunkType := string(buf[offset : offset+4])
+	length := binary.BigEndian.Uint32(buf[offset+4:])
+	end := uint64(offset) + 8 + uint64(length)
+	if end > uint64(len(buf)) {
+		return "", nil, 0, fmt.Errorf("blorb chunk %q at 0x%X runs past the end of the file", chunkType, offset)
+	}
+	data := buf[offset+8 : end]
+	// Chunks are padded to an even length
+	return chunkType, data, uint32(end + end&1), nil
+}
+
+// ReadBlorb parses a Blorb file and its resource index.
+func ReadBlorb(buf []byte) (*Blorb, error) {
+	if !IsBlorb(buf) {
+		return nil, fmt.Errorf("not a blorb file")
+	}
+	formType, form, _, err := readChunk(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	if formType != "FORM" {
+		return nil, fmt.Errorf("not a blorb file")
+	}
+	formEnd := uint32(8 + len(form))
+
+	b := new(Blorb)
+	var index []byte
+	for offset := uint32(12); offset < formEnd; {
+		chunkType, data, next, err := readChunk(buf[:formEnd], offset)
+		if err != nil {
+			return nil, err
+		}
+		switch chunkType {
+		case "RIdx":
+			index = data
+		case "IFmd":
+			b.Metadata = string(data)
+		case "Fspc":
+			if len(data) < 4 {
+				return nil, fmt.Errorf("blorb Fspc chunk too short")
+			}
+			b.Frontispiece = binary.BigEndian.Uint32(data)
+			b.HasFrontispiece = true
+		}
+		offset = next
+	}
+	if index == nil {
+		return nil, fmt.Errorf("blorb file has no resource index")
+	}
+
+	if len(index) < 4 {
+		return nil, fmt.Errorf("blorb resource index too short")
+	}
+	count := binary.BigEndian.Uint32(index)
+	if uint64(len(index)) < 4+uint64(count)*12 {
+		return nil, fmt.Errorf("blorb resource index truncated")
+	}
+	for i := uint32(0); i < count; i++ {
+		entry := index[4+i*12:]
+		r := BlorbResource{
+			Usage:  string(entry[0:4]),
+			Number: binary.BigEndian.Uint32(entry[4:]),
+		}
+		start := binary.BigEndian.Uint32(entry[8:])
+		chunkType, data, _, err := readChunk(buf[:formEnd], start)
+		if err != nil {
+			return nil, err
+		}
+		r.Type = chunkType
+		r.Data = data
+		if chunkType == "FORM" && len(data) >= 4 {
+			r.Type = string(data[0:4])
+			r.Data = buf[start : start+8+uint32(len(data))]
+		}
+		b.Resources = append(b.Resources, r)
+	}
+
+	return b, nil
+}
+
+func (b *Blorb) Resource(usage string, number uint32) (*BlorbResource, bool) {
+	for i := range b.Resources {
+		if b.Resources[i].Usage == usage && b.Resources[i].Number == number {
+			return &b.Resources[i], true
+		}
+	}
+	return nil, false
+}
+
+func (b *Blorb) Picture(number uint32) (*BlorbResource, bool) {
+	return b.Resource(BLORB_USAGE_PICTURE, number)
+}
+
+func (b *Blorb) Sound(number uint32) (*BlorbResource, bool) {
+	return b.Resource(BLORB_USAGE_SOUND, number)
+}
+
+// StoryFile returns the Z-code of the executable resource.
+func (b *Blorb) StoryFile() ([]byte, error) {
+	exec, ok := b.Resource(BLORB_USAGE_EXEC, 0)
+	if !ok {
+		return nil, fmt.Errorf("blorb file has no executable resource")
+	}
+	if exec.Type != "ZCOD" {
+		return nil, fmt.Errorf("blorb executable is %q, not Z-code", exec.Type)
+	}
+	return exec.Data, nil
+}
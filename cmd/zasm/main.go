@@ -0,0 +1,50 @@
+// Command zasm assembles a story file from the zasm text syntax.
+//
+//	zasm -o story.z3 story.zas
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/awgh/zmachine/zasm"
+)
+
+var output = flag.String("o", "", "write the story to `file` (default: source name with .z3/.z5)")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] source-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	src, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := zasm.Parse(string(src))
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+	story, err := s.Assemble()
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+
+	out := *output
+	if out == "" {
+		out = fmt.Sprintf("%s.z%d", strings.TrimSuffix(flag.Arg(0), ".zas"), s.Version)
+	}
+	if err := ioutil.WriteFile(out, story, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+// Command zbench measures the interpreter on scripted playthroughs, with
+// the plain interpreter, the decoded-instruction cache and compiled
+// routines.
+//
+//	zbench                                  the stories of bench/stories
+//	zbench -commands walkthrough.txt zork1.dat
+//	zbench -commands mini.txt mini.zas      a zasm source
+//	zbench -profile zork1.dat               opcodes and routines run
+//
+// Without -commands, a single story plays a short tour of the first
+// rooms of Zork I.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/awgh/zmachine/bench"
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+var (
+	dir          = flag.String("dir", "bench/stories", "play the stories of `directory` when no story is given")
+	commandsFile = flag.String("commands", "", "play the commands in `file`, one per line")
+	runs         = flag.Int("runs", 20, "playthroughs per story and mode")
+	profile      = flag.Bool("profile", false, "report the opcodes and routines run instead")
+	top          = flag.Int("top", 20, "routines in the profile (0 = all)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [story-file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var stories []bench.Story
+	if flag.NArg() == 1 {
+		data, err := walkthrough.ReadStory(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		commands := bench.ZORK1_TOUR
+		if *commandsFile != "" {
+			if commands, err = walkthrough.ReadCommands(*commandsFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		name := strings.TrimSuffix(filepath.Base(flag.Arg(0)), filepath.Ext(flag.Arg(0)))
+		stories = append(stories, bench.Story{Name: name, Data: data, Commands: commands})
+	} else {
+		var err error
+		if stories, err = bench.LoadStories(*dir); err != nil {
+			log.Fatal(err)
+		}
+		if len(stories) == 0 {
+			log.Fatalf("no stories with walkthroughs in %s", *dir)
+		}
+	}
+
+	for _, story := range stories {
+		if *profile {
+			p, err := bench.Profile(story)
+			if err != nil {
+				log.Fatalf("%s: %v", story.Name, err)
+			}
+			fmt.Printf("%s: ", story.Name)
+			p.Report(os.Stdout, *top)
+			fmt.Println()
+			continue
+		}
+		for _, mode := range bench.Modes {
+			result, err := bench.Run(story, mode, *runs)
+			if err != nil {
+				log.Fatalf("%s: %v", story.Name, err)
+			}
+			fmt.Println(result)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Command zcompliance runs interpreter test suites and compares their
+// pass and fail counts to a baseline file.
+//
+//	zcompliance -baseline compliance.json czech.z3 selftest.zas
+//	zcompliance -update -baseline compliance.json czech.z3
+//
+// Stories are version 3 story files or zasm sources.
+//
+// Exits with status 1 when a suite does worse than its baseline.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/awgh/zmachine/compliance"
+)
+
+var (
+	baselinePath = flag.String("baseline", "compliance.json", "compare with the results in `file`")
+	update       = flag.Bool("update", false, "record the new results as the baseline")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] story-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	baseline, err := compliance.LoadBaseline(*baselinePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	results, regressions, changed, err := compliance.RunAll(baseline, *update, compliance.DefaultOptions(), flag.Args()...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		name := filepath.Base(path)
+		r := results[name]
+		status := "ok"
+		switch {
+		case len(regressions[name]) > 0:
+			status, failed = "REGRESSED", true
+		case r.Error != "":
+			status = "error: " + r.Error
+		case r.Unreported:
+			status = "no results reported"
+		}
+		fmt.Printf("%-20s passed %5d  failed %5d  %s\n", name, r.Passed, r.Failed, status)
+		for _, msg := range regressions[name] {
+			fmt.Printf("  %s\n", msg)
+		}
+	}
+
+	if changed {
+		if err := baseline.Save(*baselinePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,53 @@
+// Command zgolden replays walkthroughs through their stories and
+// compares the transcripts with the golden ones.
+//
+//	zgolden                         the cases of bench/stories
+//	zgolden -dir testdata -update   record new golden transcripts
+//
+// Exits with status 1 when a transcript changed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/awgh/zmachine/golden"
+)
+
+var (
+	dir    = flag.String("dir", "bench/stories", "replay the stories of `directory`")
+	update = flag.Bool("update", false, "rewrite the golden transcripts")
+)
+
+func main() {
+	flag.Parse()
+	cases, err := golden.FindCases(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(cases) == 0 {
+		log.Fatalf("no stories with walkthroughs in %s", *dir)
+	}
+
+	failed := false
+	for _, c := range cases {
+		diff, err := c.Run(golden.DefaultOptions(), *update)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %v\n", c.Name, err)
+			failed = true
+		case diff != "":
+			fmt.Printf("%s: transcript differs from %s (-golden +now):\n%s", c.Name, c.Golden, diff)
+			failed = true
+		case *update:
+			fmt.Printf("%s: updated %s\n", c.Name, c.Golden)
+		default:
+			fmt.Printf("%s: ok\n", c.Name)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
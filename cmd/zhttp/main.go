@@ -0,0 +1,72 @@
+// Command zhttp hosts stories through the turn-based HTTP/JSON API of
+// package httpapi under /sessions, and to WebSocket terminals (package
+// wsterm) on /play. Each story is known by its file name without extension.
+//
+//	zhttp -addr :8080 zork1.z3 minizork.z3
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/awgh/zmachine/httpapi"
+	"github.com/awgh/zmachine/wsterm"
+)
+
+var (
+	addr        = flag.String("addr", ":8080", "HTTP address to listen on")
+	timeout     = flag.Duration("timeout", time.Hour, "drop games without commands for this long (0 = never)")
+	maxSessions = flag.Int("max", 0, "maximum number of games in progress, per API (0 = no limit)")
+	origins     = flag.String("origins", "", "comma separated `origins` of other sites whose pages may open terminals (* = any)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] story-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	api := httpapi.New()
+	api.SessionTimeout = *timeout
+	api.MaxSessions = *maxSessions
+	term := wsterm.New()
+	term.MaxSessions = *maxSessions
+	if *origins != "" {
+		term.AllowedOrigins = strings.Split(*origins, ",")
+	}
+
+	for _, path := range flag.Args() {
+		story, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err := api.AddStory(name, story); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if err := term.AddStory(name, story); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		log.Printf("story %q from %s", name, path)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/sessions", api)
+	mux.Handle("/sessions/", api)
+	mux.Handle("/play", term)
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awgh/zmachine"
+)
+
+const debuggerHelp = `commands:
+  s [n]        step n instructions (default 1)
+  c            continue until a breakpoint
+  b addr       set a breakpoint
+  d addr       delete a breakpoint
+  bl           list breakpoints
+  g n          show global variable n (0-239)
+  l n          show local variable n (1-15)
+  stack        show the stack, top first
+  o n          show object n
+  q            quit
+`
+
+type debugger struct {
+	zm          *zmachine.ZMachine
+	console     *console
+	out         io.Writer
+	breakpoints map[uint32]bool
+	// Instructions to run before stopping, 0 = run to the next breakpoint
+	steps int
+}
+
+func newDebugger(zm *zmachine.ZMachine, console *console, out io.Writer) *debugger {
+	fmt.Fprint(out, "Z-machine debugger, 'h' for help\n")
+	return &debugger{zm: zm, console: console, out: out, breakpoints: make(map[uint32]bool), steps: 1}
+}
+
+// Called before every instruction. Returns false to stop the game.
+func (d *debugger) beforeInstruction() bool {
+	ip := d.zm.IP()
+	stop := d.breakpoints[ip]
+	if d.steps > 0 {
+		d.steps--
+		stop = stop || d.steps == 0
+	}
+	if !stop {
+		return true
+	}
+
+	fmt.Fprintf(d.out, "stopped at 0x%05X\n", ip)
+	for {
+		fmt.Fprint(d.out, "(zdb) ")
+		line, err := d.console.ReadLine()
+		if err != nil {
+			return false
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		resume, quit := d.command(fields[0], fields[1:])
+		if quit {
+			return false
+		}
+		if resume {
+			return true
+		}
+	}
+}
+
+// Runs one debugger command, returns whether to resume or quit the game
+func (d *debugger) command(cmd string, args []string) (resume bool, quit bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(d.out, "error: %v\n", r)
+			resume, quit = false, false
+		}
+	}()
+
+	arg := uint64(0)
+	if len(args) > 0 {
+		var err error
+		if arg, err = strconv.ParseUint(args[0], 0, 32); err != nil {
+			fmt.Fprintf(d.out, "invalid number %q\n", args[0])
+			return false, false
+		}
+	}
+
+	switch cmd {
+	case "s", "step":
+		d.steps = 1
+		if len(args) > 0 && arg > 0 {
+			d.steps = int(arg)
+		}
+		return true, false
+	case "c", "continue":
+		d.steps = 0
+		return true, false
+	case "b", "break":
+		d.breakpoints[uint32(arg)] = true
+	case "d", "delete":
+		delete(d.breakpoints, uint32(arg))
+	case "bl":
+		var addrs []int
+		for addr := range d.breakpoints {
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			fmt.Fprintf(d.out, "0x%05X\n", addr)
+		}
+	case "g":
+		if arg > 239 {
+			fmt.Fprintln(d.out, "globals are numbered 0-239")
+			break
+		}
+		v := d.zm.ReadGlobal(uint8(arg + 0x10))
+		fmt.Fprintf(d.out, "g%d = %d (0x%X)\n", arg, int16(v), v)
+	case "l":
+		if arg < 1 || arg > 15 {
+			fmt.Fprintln(d.out, "locals are numbered 1-15")
+			break
+		}
+		v := d.zm.Stack().GetLocalVar(int(arg - 1))
+		fmt.Fprintf(d.out, "l%d = %d (0x%X)\n", arg, int16(v), v)
+	case "stack":
+		for _, v := range d.zm.Stack().Contents() {
+			fmt.Fprintf(d.out, "0x%04X\n", v)
+		}
+	case "o":
+		obj := uint16(arg)
+		fmt.Fprintf(d.out, "%d \"%s\" parent %d sibling %d child %d\n", obj, d.zm.GetObjectName(obj),
+			d.zm.GetParentObject(obj), d.zm.GetSibling(obj), d.zm.GetFirstChild(obj))
+	case "q", "quit":
+		return false, true
+	case "h", "help":
+		fmt.Fprint(d.out, debuggerHelp)
+	default:
+		fmt.Fprintf(d.out, "unknown command %q, 'h' for help\n", cmd)
+	}
+	return false, false
+}
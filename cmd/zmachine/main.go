@@ -0,0 +1,151 @@
+// Command zmachine plays a Z-machine story file in the terminal.
+//
+//	zmachine [flags] story.z3
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/awgh/zmachine"
+)
+
+var (
+	seed       = flag.Int64("seed", 0, "seed for the random number generator (0 = seeded from the clock)")
+	transcript = flag.String("transcript", "", "write a transcript of the session to `file`")
+	replay     = flag.String("replay", "", "read commands from `file` before reading the keyboard")
+	saveDir    = flag.String("savedir", ".", "`directory` where games are saved")
+	width      = flag.Int("width", 80, "screen width in characters, text is wrapped to it (0 = no wrapping)")
+	height     = flag.Int("height", 0, "screen height in lines, output pauses with [MORE] when full (0 = never)")
+	trace      = flag.Bool("trace", false, "log every instruction to stderr")
+	debug      = flag.Bool("debug", false, "start in the interactive debugger")
+	policyName = flag.String("errors", "strict", "what to do when the game breaks the rules: `strict`, warn or ignore")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] story-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(run(flag.Arg(0)))
+}
+
+func run(storyPath string) int {
+	policy, err := zmachine.ParsePolicy(*policyName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zmachine:", err)
+		return 2
+	}
+	zm, err := zmachine.LoadFile(storyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zmachine:", err)
+		return 1
+	}
+	zm.Policy = policy
+	if *seed != 0 {
+		zm.SeedRandom(*seed)
+	}
+	cfg := zm.Config()
+	cfg.ScreenWidth = screenSize(*width)
+	cfg.ScreenHeight = screenSize(*height)
+	// Plain scrolling terminal
+	cfg.StatusLine = false
+	zm.Configure(cfg)
+
+	console := &console{bufio.NewReader(os.Stdin)}
+	input := &gameInput{console: console}
+
+	var out io.Writer = os.Stdout
+	if *transcript != "" {
+		f, err := os.Create(*transcript)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "zmachine:", err)
+			return 1
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+		input.transcript = f
+	}
+	screen := &screen{out: out, term: os.Stdout, width: *width, height: *height, console: console}
+	input.screen = screen
+
+	if *replay != "" {
+		f, err := os.Open(*replay)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "zmachine:", err)
+			return 1
+		}
+		defer f.Close()
+		input.script = bufio.NewScanner(f)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(storyPath), filepath.Ext(storyPath))
+	zm.Storage = &zmachine.FileStorage{Path: filepath.Join(*saveDir, name+".sav")}
+	zm.SetInput(input)
+	zm.SetOutput(screen)
+	if *trace {
+		zm.Trace = os.Stderr
+	}
+
+	var dbg *debugger
+	if *debug {
+		dbg = newDebugger(zm, console, os.Stderr)
+	}
+
+	err = play(zm, dbg)
+	screen.Flush()
+	if err != nil {
+		// Already "zmachine: ... (IP 0x...)"
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Size as reported in the header, 0 means no limit
+func screenSize(n int) uint8 {
+	if n <= 0 || n > 255 {
+		return 255
+	}
+	return uint8(n)
+}
+
+// Runs the game one instruction at a time, for the debugger, and returns
+// the *RuntimeError or *MemoryError it stopped on
+func play(zm *zmachine.ZMachine, dbg *debugger) (err error) {
+	var start uint32
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case *zmachine.MemoryError:
+				err = e
+			case *zmachine.RuntimeError:
+				if e.IP == 0 {
+					e.IP = start
+				}
+				err = e
+			default:
+				err = &zmachine.RuntimeError{IP: start, Msg: fmt.Sprint(r)}
+			}
+		}
+	}()
+
+	for !zm.Done {
+		if dbg != nil && !dbg.beforeInstruction() {
+			break
+		}
+		start = zm.IP()
+		zm.InterpretInstruction()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Lines typed on the keyboard, shared by the game, the pager and the debugger
+type console struct {
+	r *bufio.Reader
+}
+
+func (c *console) ReadLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Player input: the replay script first, then the keyboard.
+// Hands out one line per Read so the console is never read ahead.
+type gameInput struct {
+	script     *bufio.Scanner
+	console    *console
+	screen     *screen
+	transcript io.Writer
+	pending    []byte
+}
+
+func (g *gameInput) Read(p []byte) (int, error) {
+	if len(g.pending) == 0 {
+		g.screen.Flush()
+		line, err := g.nextLine()
+		if err != nil {
+			return 0, err
+		}
+		g.pending = []byte(line + "\n")
+	}
+	n := copy(p, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+func (g *gameInput) nextLine() (string, error) {
+	if g.script != nil {
+		if g.script.Scan() {
+			line := g.script.Text()
+			// Show replayed commands as if they had been typed
+			g.screen.Write([]byte(line + "\n"))
+			g.screen.lines = 0
+			return line, nil
+		}
+		g.script = nil
+	}
+
+	line, err := g.console.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	// The terminal already echoed it
+	g.screen.col = 0
+	g.screen.lines = 0
+	if g.transcript != nil {
+		io.WriteString(g.transcript, line+"\n")
+	}
+	return line, nil
+}
+
+// Word wraps game output to the screen width and pauses when a screenful
+// has been printed since the last input.
+type screen struct {
+	out     io.Writer
+	term    io.Writer
+	width   int
+	height  int
+	console *console
+	col     int
+	lines   int
+	word    []byte
+}
+
+func (s *screen) Write(p []byte) (int, error) {
+	for _, c := range p {
+		switch c {
+		case '\n':
+			s.flushWord()
+			s.newLine()
+		case ' ':
+			s.flushWord()
+			if s.width > 0 && s.col >= s.width {
+				s.newLine()
+			} else {
+				io.WriteString(s.out, " ")
+				s.col++
+			}
+		default:
+			s.word = append(s.word, c)
+			if s.width > 0 && len(s.word) >= s.width {
+				s.flushWord()
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Writes out the pending word, the prompt before input for instance
+func (s *screen) Flush() {
+	s.flushWord()
+}
+
+func (s *screen) flushWord() {
+	if len(s.word) == 0 {
+		return
+	}
+	if s.width > 0 && s.col > 0 && s.col+len(s.word) > s.width {
+		s.newLine()
+	}
+	s.out.Write(s.word)
+	s.col += len(s.word)
+	s.word = s.word[:0]
+}
+
+func (s *screen) newLine() {
+	io.WriteString(s.out, "\n")
+	s.col = 0
+	s.lines++
+	if s.height > 0 && s.lines >= s.height-1 {
+		io.WriteString(s.term, "[MORE]")
+		s.console.ReadLine()
+		s.lines = 0
+	}
+}
@@ -0,0 +1,49 @@
+// Command zserver hosts a story for several players over telnet.
+//
+//	zserver -addr :2323 story.z3
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/awgh/zmachine/server"
+)
+
+var (
+	addr        = flag.String("addr", ":2323", "TCP address to listen on")
+	idle        = flag.Duration("idle", 30*time.Minute, "disconnect players idle for this long (0 = never)")
+	saveDir     = flag.String("savedir", "", "keep saved games in `directory`, by player name (default: in memory per session)")
+	maxSessions = flag.Int("max", 0, "maximum number of simultaneous players (0 = no limit)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] story-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	story, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv, err := server.New(story)
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+	srv.IdleTimeout = *idle
+	srv.SaveDir = *saveDir
+	srv.MaxSessions = *maxSessions
+
+	log.Printf("serving %s on %s", flag.Arg(0), *addr)
+	log.Fatal(srv.ListenAndServe(*addr))
+}
@@ -0,0 +1,376 @@
+package zmachine
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Most instructions compiled at once, in case flow analysis runs into data
+const MAX_COMPILED_INSTRUCTIONS = 4096
+
+// One instruction of a compiled routine, with its operands, store
+// variable and branch resolved. Immutable once published.
+type compiledStep struct {
+	address uint32
+	run     func(zm *ZMachine)
+	// Steps at the fall-through and branch (or jump) addresses, if compiled
+	// together. Anything else, calls and returns, is looked up.
+	next, branch *compiledStep
+
+	nextAddress, branchAddress uint32
+	hasNext, hasBranch         bool
+}
+
+// Decoded branch data of an instruction
+type compiledBranch struct {
+	onTrue bool
+	// 0 = return false, 1 = return true, 2 = jump to target
+	kind   uint8
+	target uint32
+	// Address after the branch data
+	after uint32
+}
+
+func (b *compiledBranch) take(zm *ZMachine, condition bool) {
+	if condition != b.onTrue {
+		zm.ip = b.after
+	} else if b.kind != 2 {
+		zm.ip = b.after
+		ZRet(zm, uint16(b.kind))
+	} else {
+		zm.ip = b.target
+	}
+}
+
+// Version 3 opcodes storing a result, branching, or followed by text
+var (
+	stores2OP   = bitset(8, 9, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24)
+	branches2OP = bitset(1, 2, 3, 4, 5, 6, 7, 10)
+	stores1OP   = bitset(1, 2, 3, 4, 14, 15)
+	branches1OP = bitset(0, 1, 2)
+	branches0OP = bitset(5, 6, 13, 15)
+	text0OP     = bitset(2, 3)
+	storesVAR   = bitset(0, 7)
+)
+
+func bitset(bits ...uint) uint32 {
+	var set uint32
+	for _, b := range bits {
+		set |= 1 << b
+	}
+	return set
+}
+
+// Whether the instruction stores, branches, prints inline text, and
+// whether execution can continue with the next one
+func opcodeInfo(d *decodedInstruction) (store, branch, text, fallThrough bool) {
+	bit := uint32(1) << d.instruction
+	fallThrough = true
+	switch d.kind {
+	case INSTRUCTION_0OP:
+		branch = branches0OP&bit != 0
+		text = text0OP&bit != 0
+		// rtrue, rfalse, print_ret, restart, ret_popped, quit
+		switch d.instruction {
+		case 0, 1, 3, 7, 8, 10:
+			fallThrough = false
+		}
+	case INSTRUCTION_1OP:
+		store = stores1OP&bit != 0
+		branch = branches1OP&bit != 0
+		// ret, jump
+		fallThrough = d.instruction != 11 && d.instruction != 12
+	case INSTRUCTION_2OP:
+		store = stores2OP&bit != 0
+		branch = branches2OP&bit != 0
+	case INSTRUCTION_VAR:
+		store = storesVAR&bit != 0
+	}
+	return
+}
+
+func (zm *ZMachine) decodeBranch(address uint32) compiledBranch {
+	var b compiledBranch
+	info := zm.GetUint8(address)
+	b.onTrue = info&0x80 != 0
+	var offset int32
+	if info&0x40 != 0 {
+		offset = int32(info & 0x3F)
+		b.after = address + 1
+	} else {
+		offset = int32(int16(uint16(info&0x3F)<<10) >> 2)
+		offset |= int32(zm.GetUint8(address + 1))
+		b.after = address + 2
+	}
+	b.kind = 2
+	if offset == 0 || offset == 1 {
+		b.kind = uint8(offset)
+	}
+	b.target = uint32(int32(b.after) + offset - 2)
+	return b
+}
+
+// Returns the compiled step at address, compiling the code reachable
+// from it (the whole routine, when entered at its start) on first use.
+func (zm *ZMachine) compiledStep(address uint32) *compiledStep {
+	zm.checkAddress(address)
+	steps := zm.decoded.compiledSteps()
+	if s := (*compiledStep)(atomic.LoadPointer(&steps[address-zm.decoded.base])); s != nil {
+		return s
+	}
+	return zm.compile(address)
+}
+
+func (zm *ZMachine) compile(start uint32) *compiledStep {
+	c := zm.decoded
+	steps := c.compiledSteps()
+	end := c.base + uint32(len(steps))
+	local := make(map[uint32]*compiledStep)
+
+	queue := []uint32{start}
+	for len(queue) > 0 && len(local) < MAX_COMPILED_INSTRUCTIONS {
+		address := queue[0]
+		queue = queue[1:]
+		if address < c.base || address >= end || local[address] != nil ||
+			atomic.LoadPointer(&steps[address-c.base]) != nil {
+			continue
+		}
+		s := zm.compileInstruction(address)
+		local[address] = s
+		if s.hasNext {
+			queue = append(queue, s.nextAddress)
+		}
+		if s.hasBranch {
+			queue = append(queue, s.branchAddress)
+		}
+	}
+
+	link := func(address uint32) *compiledStep {
+		if s := local[address]; s != nil {
+			return s
+		}
+		if address >= c.base && address < end {
+			return (*compiledStep)(atomic.LoadPointer(&steps[address-c.base]))
+		}
+		return nil
+	}
+	for _, s := range local {
+		if s.hasNext {
+			s.next = link(s.nextAddress)
+		}
+		if s.hasBranch {
+			s.branch = link(s.branchAddress)
+		}
+	}
+	for address, s := range local {
+		atomic.CompareAndSwapPointer(&steps[address-c.base], nil, unsafe.Pointer(s))
+	}
+	return (*compiledStep)(atomic.LoadPointer(&steps[start-c.base]))
+}
+
+// Compiles one instruction. Bytes that don't decode become a step
+// running the interpreter, which fails the same way when reached.
+func (zm *ZMachine) compileInstruction(address uint32) (s *compiledStep) {
+	defer func() {
+		if recover() != nil {
+			s = &compiledStep{address: address, run: func(zm *ZMachine) {
+				zm.runDecoded(zm.cachedInstruction(address))
+			}}
+		}
+	}()
+
+	d := zm.cachedInstruction(address)
+	store, branch, text, fallThrough := opcodeInfo(d)
+	s = &compiledStep{address: address}
+
+	after := d.next
+	var storeVar uint8
+	var b compiledBranch
+	if store {
+		storeVar = zm.GetUint8(after)
+		after++
+	}
+	if branch {
+		b = zm.decodeBranch(after)
+		after = b.after
+		if b.kind == 2 {
+			s.hasBranch, s.branchAddress = true, b.target
+		}
+	}
+	if text {
+		_, after = zm.ReadZString(after)
+	}
+	if fallThrough {
+		s.hasNext, s.nextAddress = true, after
+	}
+
+	// Jumps to a constant offset
+	if d.kind == INSTRUCTION_1OP && d.instruction == 12 && d.types[0] != OPERAND_VARIABLE {
+		target := uint32(int32(d.next) + int32(int16(d.values[0])) - 2)
+		s.hasBranch, s.branchAddress = true, target
+		s.run = func(zm *ZMachine) { zm.ip = target }
+		return s
+	}
+
+	s.run = zm.compileSpecialized(d, storeVar, &b, after)
+	if s.run == nil {
+		s.run = func(zm *ZMachine) { zm.runDecoded(d) }
+	}
+	return s
+}
+
+// Returns a getter for a decoded operand
+func compileOperand(d *decodedInstruction, i int) func(zm *ZMachine) uint16 {
+	if d.types[i] == OPERAND_VARIABLE {
+		v := uint8(d.values[i])
+		switch {
+		case v == 0:
+			return func(zm *ZMachine) uint16 { return zm.popStack() }
+		case v < 0x10:
+			return func(zm *ZMachine) uint16 { return zm.readLocal(int(v - 1)) }
+		}
+		return func(zm *ZMachine) uint16 { return zm.ReadGlobal(v) }
+	}
+	value := d.values[i]
+	return func(zm *ZMachine) uint16 { return value }
+}
+
+// Closures doing the work of the most common instructions without
+// going through the opcode functions, nil for the others
+func (zm *ZMachine) compileSpecialized(d *decodedInstruction, storeVar uint8, b *compiledBranch, after uint32) func(zm *ZMachine) {
+	br := *b
+	dest := uint16(storeVar)
+
+	if d.kind == INSTRUCTION_1OP && d.instruction == 0 {
+		// jz
+		a := compileOperand(d, 0)
+		return func(zm *ZMachine) { br.take(zm, a(zm) == 0) }
+	}
+	if d.kind != INSTRUCTION_2OP || d.numOperands != 2 {
+		return nil
+	}
+	a, c := compileOperand(d, 0), compileOperand(d, 1)
+
+	switch d.instruction {
+	case 1: // je
+		return func(zm *ZMachine) {
+			x := a(zm)
+			br.take(zm, x == c(zm))
+		}
+	case 2: // jl
+		return func(zm *ZMachine) {
+			x := a(zm)
+			br.take(zm, int16(x) < int16(c(zm)))
+		}
+	case 3: // jg
+		return func(zm *ZMachine) {
+			x := a(zm)
+			br.take(zm, int16(x) > int16(c(zm)))
+		}
+	case 4: // dec_chk
+		return func(zm *ZMachine) {
+			v := a(zm)
+			limit := c(zm)
+			br.take(zm, int16(zm.AddToVar(v, -1)) < int16(limit))
+		}
+	case 5: // inc_chk
+		return func(zm *ZMachine) {
+			v := a(zm)
+			limit := c(zm)
+			br.take(zm, int16(zm.AddToVar(v, 1)) > int16(limit))
+		}
+	case 8: // or
+		return func(zm *ZMachine) {
+			x := a(zm)
+			r := x | c(zm)
+			zm.ip = after
+			zm.StoreAtLocation(dest, r)
+		}
+	case 9: // and
+		return func(zm *ZMachine) {
+			x := a(zm)
+			r := x & c(zm)
+			zm.ip = after
+			zm.StoreAtLocation(dest, r)
+		}
+	case 15: // loadw
+		return func(zm *ZMachine) {
+			x := a(zm)
+			r := zm.LoadWord(uint32(x + c(zm)*2))
+			zm.ip = after
+			zm.StoreAtLocation(dest, r)
+		}
+	case 16: // loadb
+		return func(zm *ZMachine) {
+			x := a(zm)
+			r := uint16(zm.LoadByte(uint32(x + c(zm))))
+			zm.ip = after
+			zm.StoreAtLocation(dest, r)
+		}
+	case 20: // add
+		return func(zm *ZMachine) {
+			x := a(zm)
+			r := int16(x) + int16(c(zm))
+			zm.ip = after
+			zm.StoreAtLocation(dest, uint16(r))
+		}
+	case 21: // sub
+		return func(zm *ZMachine) {
+			x := a(zm)
+			r := int16(x) - int16(c(zm))
+			zm.ip = after
+			zm.StoreAtLocation(dest, uint16(r))
+		}
+	}
+	return nil
+}
+
+// Runs the instruction at zm.ip as compiled code
+func (zm *ZMachine) runCompiled() {
+	s := zm.step
+	if s == nil || s.address != zm.ip {
+		s = zm.compiledStep(zm.ip)
+	}
+	s.run(zm)
+	zm.step = s.follow(zm.ip)
+}
+
+// The step to run next if it's pre-bound, nil otherwise
+func (s *compiledStep) follow(ip uint32) *compiledStep {
+	if s.next != nil && ip == s.next.address {
+		return s.next
+	}
+	if s.branch != nil && ip == s.branch.address {
+		return s.branch
+	}
+	return nil
+}
+
+// Runs up to n instructions, following the pre-bound links while
+// execution stays on them. Stops early like execute.
+func (zm *ZMachine) runCompiledSteps(n int) int {
+	s := zm.step
+	for i := 1; i <= n; i++ {
+		if s == nil || s.address != zm.ip {
+			if zm.ip < zm.header.staticMemAddress {
+				// Dynamic memory may be rewritten, no compiling there
+				zm.InterpretInstruction()
+				s = nil
+				if zm.Done || zm.waiting.Kind != INPUT_NONE {
+					return i
+				}
+				continue
+			}
+			s = zm.compiledStep(zm.ip)
+		}
+		zm.instructionStart = zm.ip
+		s.run(zm)
+		s = s.follow(zm.ip)
+		if zm.Done || zm.waiting.Kind != INPUT_NONE {
+			zm.step = s
+			return i
+		}
+	}
+	zm.step = s
+	return n
+}
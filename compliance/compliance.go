@@ -0,0 +1,267 @@
+// Package compliance runs interpreter test suites — story files that
+// exercise opcodes, arithmetic, objects or text and print how many
+// checks passed and failed — and compares the counts to a baseline, so
+// conformance can only go up as opcodes are added.
+//
+// The well-known suites are not shipped; point the harness at local
+// copies, or at zasm sources such as testdata/selftest.zas. Only version
+// 3 stories load, so use the V3 builds of suites that have one:
+//
+//	func TestCompliance(t *testing.T) {
+//		compliance.Check(t, "testdata/compliance.json", *update,
+//			"testdata/selftest.zas", "testdata/czech.z3")
+//	}
+package compliance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/awgh/zmachine"
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+// Most inputs given to a suite before it's stopped
+const MAX_INPUTS = 100
+
+type Result struct {
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	// The output had no recognisable counts
+	Unreported bool `json:"unreported,omitempty"`
+	// The story crashed or went over its limits
+	Error string `json:"error,omitempty"`
+}
+
+type Options struct {
+	// Lines typed when asked for input; then Enter until MAX_INPUTS
+	Input  []string
+	Config zmachine.Config
+	Limits zmachine.Limits
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Config: zmachine.DefaultConfig(),
+		Limits: zmachine.DefaultLimits(),
+	}
+}
+
+// Run plays the suite to its end and returns its counts and output.
+func Run(story []byte, opts Options) (Result, string, error) {
+	zm, err := zmachine.LoadBytes(story)
+	if err != nil {
+		return Result{}, "", err
+	}
+	zm.Configure(opts.Config)
+	zm.Limits = opts.Limits
+	var output bytes.Buffer
+	zm.SetOutput(&output)
+
+	var result Result
+	for inputs := 0; ; inputs++ {
+		req, err := zm.RunUntilInput()
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		if zm.Done || inputs >= MAX_INPUTS {
+			break
+		}
+		line := ""
+		if inputs < len(opts.Input) {
+			line = opts.Input[inputs]
+		}
+		if req.Kind == zmachine.INPUT_CHAR {
+			key := uint16(13)
+			if line != "" {
+				key = uint16(line[0])
+			}
+			err = zm.SendKey(key)
+		} else {
+			err = zm.SendLine(line)
+		}
+		if err != nil {
+			return result, output.String(), err
+		}
+	}
+
+	text := output.String()
+	passed, failed, ok := ParseCounts(text)
+	result.Passed, result.Failed, result.Unreported = passed, failed, !ok
+	return result, text, nil
+}
+
+var (
+	passedPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bpass(?:ed)?\s*[:=]\s*(\d+)`),
+		regexp.MustCompile(`(?i)(\d+)\s+(?:tests?\s+)?passed`),
+	}
+	failedPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bfail(?:ed|ures?)?\s*[:=]\s*(\d+)`),
+		regexp.MustCompile(`(?i)(\d+)\s+(?:tests?\s+)?failed`),
+	}
+	allPassed = regexp.MustCompile(`(?i)all\s+tests\s+passed`)
+)
+
+// Last count matched by any of the patterns, -1 if none
+func lastCount(text string, patterns []*regexp.Regexp) int {
+	pos, count := -1, -1
+	for _, re := range patterns {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			if m[0] > pos {
+				pos = m[0]
+				count, _ = strconv.Atoi(text[m[2]:m[3]])
+			}
+		}
+	}
+	return count
+}
+
+// ParseCounts finds the last reported numbers of passed and failed
+// checks, in the usual forms: "Passed: 12, Failed: 0", "12 tests
+// passed", "3 failed", "All tests passed".
+func ParseCounts(output string) (passed, failed int, ok bool) {
+	passed = lastCount(output, passedPatterns)
+	failed = lastCount(output, failedPatterns)
+	if passed < 0 && failed < 0 {
+		if allPassed.MatchString(output) {
+			return 0, 0, true
+		}
+		return 0, 0, false
+	}
+	if passed < 0 {
+		passed = 0
+	}
+	if failed < 0 {
+		failed = 0
+	}
+	return passed, failed, true
+}
+
+// Results by story name
+type Baseline map[string]Result
+
+func LoadBaseline(path string) (Baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Baseline{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+func (b Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Regressions describes how now is worse than before, nil if it isn't.
+func Regressions(before, now Result) []string {
+	var r []string
+	if now.Error != "" && before.Error == "" {
+		r = append(r, "now fails with: "+now.Error)
+	}
+	if now.Unreported && !before.Unreported {
+		r = append(r, "no longer reports its results")
+	}
+	if now.Passed < before.Passed {
+		r = append(r, fmt.Sprintf("passed %d, was %d", now.Passed, before.Passed))
+	}
+	if now.Failed > before.Failed {
+		r = append(r, fmt.Sprintf("failed %d, was %d", now.Failed, before.Failed))
+	}
+	return r
+}
+
+// RunAll runs the stories, story files or zasm sources, and compares
+// them to the baseline, where a missing story is a regression. With
+// update, the baseline takes every new result instead. Returns the
+// results and the regressions, by story name, and whether the baseline
+// changed.
+func RunAll(baseline Baseline, update bool, opts Options, paths ...string) (map[string]Result, map[string][]string, bool, error) {
+	results := make(map[string]Result)
+	regressions := make(map[string][]string)
+	changed := false
+	for _, path := range paths {
+		story, err := walkthrough.ReadStory(path)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		name := filepath.Base(path)
+		now, _, err := Run(story, opts)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("%s: %v", name, err)
+		}
+		results[name] = now
+
+		before, known := baseline[name]
+		switch {
+		case update:
+			if !known || before != now {
+				baseline[name] = now
+				changed = true
+			}
+		case !known:
+			regressions[name] = []string{"not in the baseline, run with update to record it"}
+		default:
+			regressions[name] = Regressions(before, now)
+		}
+	}
+	return results, regressions, changed, nil
+}
+
+// Check runs the stories under go test, failing t on any regression
+// from the baseline file. With update, the file is rewritten with the
+// new results instead. Story files that don't exist are skipped.
+func Check(t testing.TB, baselinePath string, update bool, paths ...string) {
+	t.Helper()
+	baseline, err := LoadBaseline(baselinePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var present []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			present = append(present, path)
+		} else {
+			t.Logf("%s: skipped, no such story", path)
+		}
+	}
+	results, regressions, changed, err := RunAll(baseline, update, DefaultOptions(), present...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range present {
+		name := filepath.Base(path)
+		r := results[name]
+		t.Logf("%s: passed %d, failed %d %s", name, r.Passed, r.Failed, r.Error)
+		for _, msg := range regressions[name] {
+			t.Errorf("%s: %s", name, msg)
+		}
+	}
+
+	if changed {
+		if err := baseline.Save(baselinePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package compliance
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "record the new results as the baseline")
+
+// The bundled V3 stories: the self test, and the bench stories, which
+// report no counts but must not crash
+func TestCompliance(t *testing.T) {
+	stories, err := filepath.Glob(filepath.Join("..", "bench", "stories", "*.zas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stories = append(stories, filepath.Join("testdata", "selftest.zas"))
+	Check(t, filepath.Join("testdata", "compliance.json"), *update, stories...)
+}
+
+func TestParseCounts(t *testing.T) {
+	for _, test := range []struct {
+		output         string
+		passed, failed int
+		ok             bool
+	}{
+		{"Passed: 12, Failed: 0\n", 12, 0, true},
+		{"12 tests passed\n3 failed\n", 12, 3, true},
+		{"pass=4 fail=1, then pass=5 fail=0", 5, 0, true},
+		{"All tests passed.", 0, 0, true},
+		{"Check 3 failed: got 2\nPassed: 2, Failed: 1", 2, 1, true},
+		{"West of House", 0, 0, false},
+	} {
+		passed, failed, ok := ParseCounts(test.output)
+		if passed != test.passed || failed != test.failed || ok != test.ok {
+			t.Errorf("%q: %d passed, %d failed, %v", test.output, passed, failed, ok)
+		}
+	}
+}
+
+func TestRegressions(t *testing.T) {
+	before := Result{Passed: 20, Failed: 1}
+	if r := Regressions(before, Result{Passed: 21}); r != nil {
+		t.Errorf("better result: %q", r)
+	}
+	if r := Regressions(before, Result{Passed: 19, Failed: 2, Error: "crash"}); len(r) != 3 {
+		t.Errorf("worse result: %q", r)
+	}
+}
+
+// Without update, the baseline is only read
+func TestRunAll(t *testing.T) {
+	story := filepath.Join("testdata", "selftest.zas")
+	baseline := Baseline{}
+	_, regressions, changed, err := RunAll(baseline, false, DefaultOptions(), story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || len(baseline) != 0 || len(regressions["selftest.zas"]) != 1 {
+		t.Errorf("unknown story: changed %v, baseline %v, regressions %q", changed, baseline, regressions)
+	}
+
+	results, regressions, changed, err := RunAll(baseline, true, DefaultOptions(), story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || baseline["selftest.zas"] != results["selftest.zas"] || len(regressions) != 0 {
+		t.Errorf("update: changed %v, baseline %v, regressions %q", changed, baseline, regressions)
+	}
+	if _, _, changed, _ := RunAll(baseline, false, DefaultOptions(), story); changed {
+		t.Error("baseline changed without update")
+	}
+}
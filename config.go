@@ -0,0 +1,123 @@
+package zmachine
+
+// Interpreter numbers (header byte 0x1E)
+const (
+	INTERPRETER_DECSYSTEM_20  = 1
+	INTERPRETER_APPLE_IIE     = 2
+	INTERPRETER_MACINTOSH     = 3
+	INTERPRETER_AMIGA         = 4
+	INTERPRETER_ATARI_ST      = 5
+	INTERPRETER_IBM_PC        = 6
+	INTERPRETER_COMMODORE_128 = 7
+	INTERPRETER_COMMODORE_64  = 8
+	INTERPRETER_APPLE_IIC     = 9
+	INTERPRETER_APPLE_IIGS    = 10
+	INTERPRETER_TANDY_COLOR   = 11
+)
+
+// Colours for the V5 default colour fields
+const (
+	COLOUR_BLACK   = 2
+	COLOUR_RED     = 3
+	COLOUR_GREEN   = 4
+	COLOUR_YELLOW  = 5
+	COLOUR_BLUE    = 6
+	COLOUR_MAGENTA = 7
+	COLOUR_CYAN    = 8
+	COLOUR_WHITE   = 9
+	COLOUR_GREY    = 10
+)
+
+// Header fields the interpreter fills in to describe its front end.
+// Screen sizes of 255 mean "infinite".
+type Config struct {
+	InterpreterNumber  uint8
+	InterpreterVersion uint8
+	ScreenWidth        uint8
+	ScreenHeight       uint8
+	// Front end capabilities
+	StatusLine    bool
+	SplitScreen   bool
+	VariablePitch bool
+	Colours       bool
+	Bold          bool
+	Italic        bool
+	FixedSpace    bool
+	TimedInput    bool
+	// Version 5+ screen model
+	FontWidth         uint8
+	FontHeight        uint8
+	DefaultBackground uint8
+	DefaultForeground uint8
+	// Standard revision the interpreter claims to follow, major in the top
+	// byte. Zero by default: set it only for an interpreter that conforms.
+	StandardRevision uint16
+}
+
+func DefaultConfig() Config {
+	return Config{
+		InterpreterNumber:  INTERPRETER_IBM_PC,
+		InterpreterVersion: 'A',
+		ScreenWidth:        80,
+		ScreenHeight:       24,
+		StatusLine:         true,
+		FontWidth:          1,
+		FontHeight:         1,
+		DefaultBackground:  COLOUR_BLACK,
+		DefaultForeground:  COLOUR_WHITE,
+	}
+}
+
+// Configure sets the interpreter header fields. They are written again
+// after restart and restore, which reload the header from the game.
+func (zm *ZMachine) Configure(cfg Config) {
+	zm.config = cfg
+	zm.writeConfig()
+}
+
+func (zm *ZMachine) Config() Config {
+	return zm.config
+}
+
+func setFlag(flags uint8, bit uint8, set bool) uint8 {
+	if set {
+		return flags | (1 << bit)
+	}
+	return flags &^ (1 << bit)
+}
+
+func (zm *ZMachine) writeConfig() {
+	cfg := zm.config
+	flags1 := zm.GetUint8(0x1)
+
+	if zm.header.Version <= 3 {
+		// Bit 4 is set when the status line is *not* available
+		flags1 = setFlag(flags1, 4, !cfg.StatusLine)
+		flags1 = setFlag(flags1, 5, cfg.SplitScreen)
+		flags1 = setFlag(flags1, 6, cfg.VariablePitch)
+	} else {
+		flags1 = setFlag(flags1, 0, cfg.Colours)
+		flags1 = setFlag(flags1, 2, cfg.Bold)
+		flags1 = setFlag(flags1, 3, cfg.Italic)
+		flags1 = setFlag(flags1, 4, cfg.FixedSpace)
+		flags1 = setFlag(flags1, 7, cfg.TimedInput)
+	}
+	zm.SetUint8(0x1, flags1)
+
+	zm.SetUint8(0x1E, cfg.InterpreterNumber)
+	zm.SetUint8(0x1F, cfg.InterpreterVersion)
+	zm.SetUint8(0x20, cfg.ScreenHeight)
+	zm.SetUint8(0x21, cfg.ScreenWidth)
+
+	if zm.header.Version >= 5 {
+		// Screen size in units
+		zm.SetUint16(0x22, uint16(cfg.ScreenWidth)*uint16(cfg.FontWidth))
+		zm.SetUint16(0x24, uint16(cfg.ScreenHeight)*uint16(cfg.FontHeight))
+		zm.SetUint8(0x26, cfg.FontWidth)
+		zm.SetUint8(0x27, cfg.FontHeight)
+		zm.SetUint8(0x2C, cfg.DefaultBackground)
+		zm.SetUint8(0x2D, cfg.DefaultForeground)
+	}
+
+	zm.SetUint16(0x32, cfg.StandardRevision)
+}
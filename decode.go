@@ -0,0 +1,171 @@
+package zmachine
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Instruction kinds, by operand count
+const (
+	INSTRUCTION_0OP = iota
+	INSTRUCTION_1OP
+	INSTRUCTION_2OP
+	INSTRUCTION_VAR
+)
+
+// An instruction decoded up to its operands. Store and branch bytes
+// are still read by the opcode functions.
+type decodedInstruction struct {
+	kind        uint8
+	instruction uint8
+	numOperands uint8
+	// Length of the operand slice given to the function: 2 in long form, 4 in variable form
+	argsLength uint8
+	types      [4]uint8
+	// Constants, or variable numbers for OPERAND_VARIABLE
+	values [4]uint16
+	// Address of the store or branch byte, or next instruction
+	next uint32
+
+	fn0 ZFunction0Op
+	fn1 ZFunction1Op
+	fn  ZFunction
+}
+
+// Decoded instructions of static and high memory, which never change,
+// by address. Shared by clones, which may run concurrently: entries are
+// written once and published atomically, so losing a race only decodes twice.
+type instructionCache struct {
+	base    uint32
+	entries []unsafe.Pointer
+
+	// Compiled steps by address, the same way
+	stepsOnce sync.Once
+	steps     []unsafe.Pointer
+}
+
+func newInstructionCache(base uint32, size int) *instructionCache {
+	c := &instructionCache{base: base}
+	if size > int(base) {
+		c.entries = make([]unsafe.Pointer, size-int(base))
+	}
+	return c
+}
+
+func (c *instructionCache) compiledSteps() []unsafe.Pointer {
+	c.stepsOnce.Do(func() {
+		c.steps = make([]unsafe.Pointer, len(c.entries))
+	})
+	return c.steps
+}
+
+func (zm *ZMachine) cachedInstruction(address uint32) *decodedInstruction {
+	zm.checkAddress(address)
+	entry := &zm.decoded.entries[address-zm.decoded.base]
+	if d := (*decodedInstruction)(atomic.LoadPointer(entry)); d != nil {
+		return d
+	}
+	d := zm.decodeInstruction(address)
+	atomic.StorePointer(entry, unsafe.Pointer(d))
+	return d
+}
+
+// Same decoding as InterpretInstruction, without reading variables
+func (zm *ZMachine) decodeInstruction(address uint32) *decodedInstruction {
+	d := new(decodedInstruction)
+	opcode := zm.GetUint8(address)
+	address++
+
+	operand := func(opType uint8) {
+		d.types[d.numOperands] = opType
+		if opType == OPERAND_LARGE {
+			d.values[d.numOperands] = zm.GetUint16(address)
+			address += 2
+		} else {
+			d.values[d.numOperands] = uint16(zm.GetUint8(address))
+			address++
+		}
+		d.numOperands++
+	}
+
+	switch (opcode >> 6) & 0x3 {
+	case 0x2:
+		opType := (opcode >> 4) & 0x3
+		d.instruction = opcode & 0x0F
+		if opType == OPERAND_OMITTED {
+			d.kind = INSTRUCTION_0OP
+			d.fn0 = ZFunctions_0P[d.instruction]
+		} else {
+			d.kind = INSTRUCTION_1OP
+			d.fn1 = ZFunctions_1OP[d.instruction]
+			operand(opType)
+		}
+	case 0x3:
+		d.instruction = opcode & 0x1F
+		d.argsLength = 4
+		if (opcode>>5)&0x1 == 0 {
+			d.kind = INSTRUCTION_2OP
+			d.fn = ZFunctions_2OP[d.instruction]
+		} else {
+			d.kind = INSTRUCTION_VAR
+			d.fn = ZFunctions_VAR[d.instruction]
+		}
+		opTypesByte := zm.GetUint8(address)
+		address++
+		for shift := 6; shift >= 0; shift -= 2 {
+			opType := (opTypesByte >> uint(shift)) & 0x3
+			if opType == OPERAND_OMITTED {
+				break
+			}
+			operand(opType)
+		}
+	default:
+		d.instruction = opcode & 0x1F
+		d.argsLength = 2
+		d.kind = INSTRUCTION_2OP
+		d.fn = ZFunctions_2OP[d.instruction]
+		operand(((opcode & 0x40) >> 6) + 1)
+		operand(((opcode & 0x20) >> 5) + 1)
+	}
+
+	d.next = address
+	return d
+}
+
+func (zm *ZMachine) runDecoded(d *decodedInstruction) {
+	args := &zm.operands
+	for i := uint8(0); i < d.numOperands; i++ {
+		if d.types[i] == OPERAND_VARIABLE {
+			args[i] = zm.readVariable(uint8(d.values[i]))
+		} else {
+			args[i] = d.values[i]
+		}
+	}
+	for i := d.numOperands; i < d.argsLength; i++ {
+		args[i] = 0
+	}
+	zm.ip = d.next
+
+	switch d.kind {
+	case INSTRUCTION_0OP:
+		if zm.Trace != nil {
+			zm.traceInstruction(ZFunctionNames_0P, d.instruction, nil)
+		}
+		d.fn0(zm)
+	case INSTRUCTION_1OP:
+		if zm.Trace != nil {
+			zm.traceInstruction(ZFunctionNames_1OP, d.instruction, args[:1])
+		}
+		d.fn1(zm, args[0])
+	default:
+		if zm.Trace != nil {
+			names := ZFunctionNames_VAR
+			if d.kind == INSTRUCTION_2OP {
+				names = ZFunctionNames_2OP
+			}
+			zm.traceInstruction(names, d.instruction, args[:d.numOperands])
+		}
+		d.fn(zm, args[:d.argsLength], uint16(d.numOperands))
+	}
+}
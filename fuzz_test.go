@@ -0,0 +1,213 @@
+package zmachine
+
+// Native fuzz targets: go test -fuzz=FuzzRun. A malformed story must only
+// ever make the interpreter fail one of its own checks, with a
+// *RuntimeError or *MemoryError. Anything else, an index out of range, a
+// nil function or a panic with a plain message, is a crash and panics
+// through to the fuzzer.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+// Layout of the story built around fuzzed code
+const (
+	FUZZ_GLOBALS       = 0x40
+	FUZZ_OBJECTS       = 0x220
+	FUZZ_PROPERTIES    = 0x300
+	FUZZ_DICTIONARY    = 0x800
+	FUZZ_ABBREVIATIONS = 0x810
+	FUZZ_CODE          = 0x900
+)
+
+// Most instructions and inputs of a fuzzed run
+const (
+	FUZZ_INSTRUCTIONS = 10000
+	FUZZ_INPUTS       = 10
+)
+
+// Seeds every target with the benchmark stories and a few instructions
+func fuzzSeeds(f *testing.F) {
+	stories, err := filepath.Glob(filepath.Join("bench", "stories", "*.zas"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range stories {
+		story, err := walkthrough.ReadStory(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(story)
+	}
+	// quit; print_num 4 then new_line and quit; loadw and store
+	f.Add([]byte{0xBA})
+	f.Add([]byte{0xE6, 0x7F, 0x04, 0xBB, 0xBA})
+	f.Add([]byte{0x0F, 0x00, 0x01, 0x10, 0xBA})
+}
+
+// Lets the panics of the interpreter's checks through as a failure, and
+// panics again on anything else
+func fuzzRecover() {
+	if r := recover(); r != nil {
+		switch r.(type) {
+		case *RuntimeError, *MemoryError:
+		default:
+			panic(r)
+		}
+	}
+}
+
+// Errors recovered from anything but the interpreter's checks keep what
+// was recovered
+func fuzzCheck(err error) {
+	if e, ok := err.(*RuntimeError); ok && e.cause != nil {
+		panic(fmt.Sprintf("%v at IP 0x%X", e.cause, e.IP))
+	}
+}
+
+func putUint16(buf []byte, offset uint32, v uint16) {
+	buf[offset] = uint8(v >> 8)
+	buf[offset+1] = uint8(v)
+}
+
+// A valid story running code from FUZZ_CODE: two objects, an empty
+// dictionary, and abbreviations that are the Z-strings at the start of
+// the code.
+func fuzzStory(code []byte) []byte {
+	story := make([]byte, FUZZ_CODE, FUZZ_CODE+len(code)+1)
+	story[0] = 3
+	putUint16(story, 0x4, FUZZ_CODE)
+	putUint16(story, 0x6, FUZZ_CODE)
+	putUint16(story, 0x8, FUZZ_DICTIONARY)
+	putUint16(story, 0xA, FUZZ_OBJECTS)
+	putUint16(story, 0xC, FUZZ_GLOBALS)
+	putUint16(story, 0xE, FUZZ_DICTIONARY)
+	putUint16(story, 0x18, FUZZ_ABBREVIATIONS)
+
+	for obj := uint32(0); obj < 2; obj++ {
+		putUint16(story, FUZZ_OBJECTS+31*2+obj*OBJECT_ENTRY_SIZE+7, FUZZ_PROPERTIES)
+	}
+	// No separators, 7 byte entries, none of them
+	story[FUZZ_DICTIONARY+1] = 7
+	for i := uint32(0); i < 96; i++ {
+		putUint16(story, FUZZ_ABBREVIATIONS+i*2, uint16((FUZZ_CODE+i*2)/2))
+	}
+
+	story = append(story, code...)
+	if len(code) == 0 {
+		// quit
+		story = append(story, 0xBA)
+	}
+	return story
+}
+
+// Loads data as a story file or Blorb file and reads its tables
+func FuzzHeader(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		defer fuzzRecover()
+		zm, err := LoadBytes(data)
+		if err != nil {
+			return
+		}
+		zm.Verify()
+		zm.StoryID()
+		zm.NumObjects()
+	})
+}
+
+// Decodes data as a Z-string, with abbreviations
+func FuzzZString(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		defer fuzzRecover()
+		zm, err := LoadBytes(fuzzStory(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zm.ReadZString(FUZZ_CODE)
+	})
+}
+
+// Decodes data as a sequence of instructions, with their store
+// variables, branches and inline text
+func FuzzInstruction(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		defer fuzzRecover()
+		story := fuzzStory(data)
+		zm, err := LoadBytes(story)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for address := uint32(FUZZ_CODE); address < uint32(len(story)); {
+			d := zm.decodeInstruction(address)
+			store, branch, text, _ := opcodeInfo(d)
+			address = d.next
+			if store {
+				address++
+			}
+			if branch {
+				address = zm.decodeBranch(address).after
+			}
+			if text {
+				_, address = zm.ReadZString(address)
+			}
+		}
+	})
+}
+
+// Runs data for a few turns with the plain interpreter and with
+// compiled routines, carrying on past spec violations: as a story file
+// if it loads, as code otherwise
+func FuzzRun(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzRunModes(t, data)
+	})
+}
+
+func fuzzRunModes(t *testing.T, data []byte) {
+	if _, err := LoadBytes(data); err != nil {
+		data = fuzzStory(data)
+	}
+	for _, compile := range []bool{false, true} {
+		zm, err := LoadBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zm.DisableCache = !compile
+		zm.CompileRoutines = compile
+		if compile {
+			zm.Policy = POLICY_IGNORE
+		}
+		zm.Limits = Limits{Instructions: FUZZ_INSTRUCTIONS, Time: time.Second}
+		zm.SetOutput(ioutil.Discard)
+		fuzzRun(zm)
+	}
+}
+
+func fuzzRun(zm *ZMachine) {
+	for inputs := 0; inputs < FUZZ_INPUTS; inputs++ {
+		req, err := zm.RunUntilInput()
+		fuzzCheck(err)
+		if err != nil || zm.Done {
+			return
+		}
+		if req.Kind == INPUT_CHAR {
+			err = zm.SendKey(13)
+		} else {
+			err = zm.SendLine("look")
+		}
+		fuzzCheck(err)
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package golden
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unchanged lines shown around each change
+const DIFF_CONTEXT = 3
+
+// Largest table compared line by line; bigger changes show as one block
+const MAX_DIFF_CELLS = 4 << 20
+
+type diffLine struct {
+	// ' ', '-' or '+'
+	op   byte
+	text string
+}
+
+// Diff returns a unified diff of the lines of want and got, "" if
+// they're the same.
+func Diff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	a, b := splitLines(want), splitLines(got)
+
+	// The common ends don't go through the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, s := range a[:prefix] {
+		lines = append(lines, diffLine{' ', s})
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, s := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', s})
+	}
+	return formatHunks(lines)
+}
+
+// Lines of s, marking a missing final newline like diff does
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file"
+	return lines
+}
+
+// Edit script from a to b through their longest common subsequence
+func diffMiddle(a, b []string) []diffLine {
+	var lines []diffLine
+	if len(a)*len(b) > MAX_DIFF_CELLS {
+		for _, s := range a {
+			lines = append(lines, diffLine{'-', s})
+		}
+		for _, s := range b {
+			lines = append(lines, diffLine{'+', s})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// Groups the changes with their context into hunks
+func formatHunks(lines []diffLine) string {
+	var out strings.Builder
+	// Line numbers in want and got at the start of each diff line
+	aLine, bLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for k, l := range lines {
+		aLine[k+1], bLine[k+1] = aLine[k], bLine[k]
+		if l.op != '+' {
+			aLine[k+1]++
+		}
+		if l.op != '-' {
+			bLine[k+1]++
+		}
+	}
+
+	for k := 0; k < len(lines); {
+		if lines[k].op == ' ' {
+			k++
+			continue
+		}
+		start := k - DIFF_CONTEXT
+		if start < 0 {
+			start = 0
+		}
+		// Extend over changes closer than twice the context
+		end, unchanged := k, 0
+		for end < len(lines) && unchanged <= 2*DIFF_CONTEXT {
+			if lines[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		end -= unchanged - DIFF_CONTEXT
+		if unchanged < DIFF_CONTEXT {
+			end = len(lines)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[end]), hunkRange(bLine[start], bLine[end]))
+		for _, l := range lines[start:end] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+		k = end
+	}
+	return out.String()
+}
+
+// "start,length" of lines after+1 to end; an empty range starts at the
+// line before it, like diff's
+func hunkRange(after, end int) string {
+	if after == end {
+		return fmt.Sprintf("%d,0", after)
+	}
+	return fmt.Sprintf("%d,%d", after+1, end-after)
+}
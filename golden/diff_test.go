@@ -0,0 +1,59 @@
+package golden
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	// Twenty numbered lines; line 5 changed, 15 removed and 21 added
+	var want, got []string
+	for i := 1; i <= 20; i++ {
+		want = append(want, fmt.Sprint("line ", i))
+	}
+	got = append(got, want...)
+	got[4] = "LINE 5"
+	got = append(got[:14], got[15:]...)
+	got = append(got, "line 21")
+
+	for _, test := range []struct {
+		name      string
+		want, got string
+		diff      string
+	}{
+		{name: "same", want: "a\nb\n", got: "a\nb\n", diff: ""},
+		{name: "two hunks", want: strings.Join(want, "\n") + "\n", got: strings.Join(got, "\n") + "\n", diff: `@@ -2,7 +2,7 @@
+ line 2
+ line 3
+ line 4
+-line 5
++LINE 5
+ line 6
+ line 7
+ line 8
+@@ -12,9 +12,9 @@
+ line 12
+ line 13
+ line 14
+-line 15
+ line 16
+ line 17
+ line 18
+ line 19
+ line 20
++line 21
+`},
+		{name: "no final newline", want: "a\nb\n", got: "a\nc", diff: `@@ -1,2 +1,2 @@
+ a
+-b
++c
+\ No newline at end of file
+`},
+		{name: "from nothing", want: "", got: "a\n", diff: "@@ -0,0 +1,1 @@\n+a\n"},
+	} {
+		if diff := Diff(test.want, test.got); diff != test.diff {
+			t.Errorf("%s: diff\n%s\nwant\n%s", test.name, diff, test.diff)
+		}
+	}
+}
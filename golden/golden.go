@@ -0,0 +1,172 @@
+// Package golden replays walkthroughs through stories and compares the
+// whole transcript, game output and typed commands, with a checked-in
+// golden file, so any change in behaviour shows up as a diff.
+//
+// A directory of cases holds stories with walkthroughs (see package
+// walkthrough), each with its transcript ending in .golden:
+//
+//	var update = flag.Bool("update", false, "rewrite the golden transcripts")
+//
+//	func TestWalkthroughs(t *testing.T) {
+//		golden.CheckDir(t, "testdata", *update)
+//	}
+package golden
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/awgh/zmachine"
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+// Random seed of the replays, so games using random numbers replay the same
+const DEFAULT_SEED = 1
+
+type Options struct {
+	Config zmachine.Config
+	Limits zmachine.Limits
+	Seed   int64
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Config: zmachine.DefaultConfig(),
+		Limits: zmachine.DefaultLimits(),
+		Seed:   DEFAULT_SEED,
+	}
+}
+
+// Replay plays the commands and returns the transcript, with each command
+// after the prompt it answers, as typed at a terminal. A key request
+// takes the first character of its command, Enter if it's empty. Stops
+// when the game ends or asks for more input than there are commands.
+func Replay(story []byte, commands []string, opts Options) (string, error) {
+	zm, err := zmachine.LoadBytes(story)
+	if err != nil {
+		return "", err
+	}
+	zm.Configure(opts.Config)
+	zm.Limits = opts.Limits
+	if opts.Seed != 0 {
+		zm.SeedRandom(opts.Seed)
+	}
+	var transcript bytes.Buffer
+	zm.SetOutput(&transcript)
+
+	for _, command := range commands {
+		req, err := zm.RunUntilInput()
+		if err != nil {
+			return transcript.String(), err
+		}
+		if zm.Done {
+			return transcript.String(), nil
+		}
+		transcript.WriteString(command + "\n")
+		if req.Kind == zmachine.INPUT_CHAR {
+			key := uint16(13)
+			if command != "" {
+				key = uint16(command[0])
+			}
+			err = zm.SendKey(key)
+		} else {
+			err = zm.SendLine(command)
+		}
+		if err != nil {
+			return transcript.String(), err
+		}
+	}
+	_, err = zm.RunUntilInput()
+	return transcript.String(), err
+}
+
+// A story, its walkthrough and its golden transcript
+type Case struct {
+	Name     string
+	Story    string
+	Commands string
+	Golden   string
+}
+
+// FindCases returns the stories of dir that have a walkthrough.
+func FindCases(dir string) ([]Case, error) {
+	walkthroughs, err := walkthrough.Find(dir)
+	if err != nil {
+		return nil, err
+	}
+	var cases []Case
+	for _, w := range walkthroughs {
+		cases = append(cases, Case{
+			Name:     w.Name,
+			Story:    w.Story,
+			Commands: w.Commands,
+			Golden:   strings.TrimSuffix(w.Commands, ".txt") + ".golden",
+		})
+	}
+	return cases, nil
+}
+
+// Run replays the case and returns how the transcript differs from the
+// golden one, "" if it doesn't. With update, the golden transcript is
+// rewritten instead.
+func (c Case) Run(opts Options, update bool) (string, error) {
+	story, err := walkthrough.ReadStory(c.Story)
+	if err != nil {
+		return "", err
+	}
+	commands, err := walkthrough.ReadCommands(c.Commands)
+	if err != nil {
+		return "", err
+	}
+	got, err := Replay(story, commands, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if update {
+		return "", ioutil.WriteFile(c.Golden, []byte(got), 0644)
+	}
+	want, err := ioutil.ReadFile(c.Golden)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no golden transcript %s, run with update to record it", c.Golden)
+	}
+	if err != nil {
+		return "", err
+	}
+	return Diff(strings.Replace(string(want), "\r\n", "\n", -1), got), nil
+}
+
+// Check replays each case as a subtest of t, failing it with a diff
+// when the transcript changed.
+func Check(t *testing.T, update bool, cases ...Case) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			diff, err := c.Run(DefaultOptions(), update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff != "" {
+				t.Errorf("transcript differs from %s (-golden +now):\n%s", c.Golden, diff)
+			}
+		})
+	}
+}
+
+// CheckDir checks all the cases of dir.
+func CheckDir(t *testing.T, dir string, update bool) {
+	t.Helper()
+	cases, err := FindCases(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no stories with walkthroughs in %s", dir)
+	}
+	Check(t, update, cases...)
+}
@@ -0,0 +1,15 @@
+package golden
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden transcripts")
+
+// The transcripts of the bench stories: go test ./golden -update
+// records new ones
+func TestGolden(t *testing.T) {
+	CheckDir(t, filepath.Join("..", "bench", "stories"), *update)
+}
@@ -0,0 +1,536 @@
+package zmachine
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Grammar table formats
+const (
+	GRAMMAR_INFOCOM = iota + 1
+	GRAMMAR_INFORM_GV1
+	GRAMMAR_INFORM_GV2
+)
+
+// Infocom dictionary data: a byte of parts of speech, whose bottom two
+// bits say which one the first value byte belongs to, then two values
+const (
+	INFOCOM_PS_OBJECT      = 0x80
+	INFOCOM_PS_VERB        = 0x40
+	INFOCOM_PS_ADJECTIVE   = 0x20
+	INFOCOM_PS_DIRECTION   = 0x10
+	INFOCOM_PS_PREPOSITION = 0x08
+	INFOCOM_PS_BUZZ_WORD   = 0x04
+
+	INFOCOM_P1_OBJECT    = 0
+	INFOCOM_P1_VERB      = 1
+	INFOCOM_P1_ADJECTIVE = 2
+	INFOCOM_P1_DIRECTION = 3
+)
+
+// Inform dictionary data: #dict_par1 flags, verb number, preposition number
+const (
+	INFORM_DICT_VERB        = 0x01
+	INFORM_DICT_META        = 0x02
+	INFORM_DICT_PLURAL      = 0x04
+	INFORM_DICT_PREPOSITION = 0x08
+	INFORM_DICT_NOUN        = 0x80
+)
+
+// Longest syntax block accepted while looking for the grammar table
+const MAX_GRAMMAR_LINES = 64
+
+// Placeholder for objects in templates
+const TEMPLATE_OBJECT = "OBJ"
+
+type Grammar struct {
+	Format int
+	// Address of the verb table
+	Address uint32
+	Verbs   []GrammarVerb
+}
+
+type GrammarVerb struct {
+	// Counting down from 255, as stored in the dictionary
+	Number uint8
+	// Synonyms, in dictionary order
+	Words    []string
+	Syntaxes []GrammarSyntax
+}
+
+type GrammarSyntax struct {
+	Action int
+	// Such as "put OBJ in OBJ", with the verb's first word
+	Template string
+	Objects  int
+	// The syntax takes numbers, topics or parsing routines,
+	// which templates can't express
+	Special bool
+}
+
+type dictEntry struct {
+	address uint32
+	word    string
+	data    []uint8
+}
+
+func (zm *ZMachine) dictionaryEntries() []dictEntry {
+	numSeparators := uint32(zm.GetUint8(zm.header.dictAddress))
+	entryLength := uint32(zm.GetUint8(zm.header.dictAddress + 1 + numSeparators))
+	numEntries := uint32(zm.GetUint16(zm.header.dictAddress + 1 + numSeparators + 1))
+	entriesAddress := zm.header.dictAddress + 1 + numSeparators + 1 + 2
+
+	entries := make([]dictEntry, numEntries)
+	for i := range entries {
+		address := entriesAddress + uint32(i)*entryLength
+		word, _ := zm.ReadZString(address)
+		data := make([]uint8, 0, entryLength)
+		for j := uint32(4); j < entryLength; j++ {
+			data = append(data, zm.GetUint8(address+j))
+		}
+		entries[i] = dictEntry{address, word, data}
+	}
+	return entries
+}
+
+// Value of an Infocom word for a part of speech, like the WT? parser routine
+func infocomWordValue(data []uint8, ps uint8, p1 uint8) (uint8, bool) {
+	if len(data) < 3 || data[0]&ps == 0 {
+		return 0, false
+	}
+	if data[0]&0x3 == p1 {
+		return data[1], true
+	}
+	return data[2], true
+}
+
+// Inform writes its version at 0x3C, e.g. "6.21"
+func (zm *ZMachine) isInform() bool {
+	v := zm.GetUint8(0x3C)
+	return (v == '5' || v == '6') && zm.GetUint8(0x3D) == '.'
+}
+
+type grammarReader struct {
+	zm     *ZMachine
+	format int
+	verbs  map[uint8][]string
+	// Preposition words by number (Infocom, GV1) or dictionary address (GV2)
+	prepositions map[uint32]string
+}
+
+// Grammar finds and decodes the verb syntaxes. Neither format is pointed
+// to by the header, so the table is searched for between the header and
+// the dictionary, which both compilers write after it: a word per verb,
+// each pointing to a block of syntaxes that decodes without errors.
+//
+// Words are the dictionary's, cut to six letters in version 3, so
+// templates can read "restar" for "restart"; the game understands them.
+func (zm *ZMachine) Grammar() (g *Grammar, err error) {
+	defer zm.recoverError(&err)
+	inform := zm.isInform()
+	verbs := make(map[uint8][]string)
+	prepositions := make(map[uint32]string)
+	lowest := 256
+
+	for _, e := range zm.dictionaryEntries() {
+		var verb uint8
+		isVerb := false
+		if inform {
+			if len(e.data) >= 3 {
+				isVerb = e.data[0]&INFORM_DICT_VERB != 0
+				verb = e.data[1]
+				if e.data[0]&INFORM_DICT_PREPOSITION != 0 {
+					// GV1 numbers prepositions, GV2 uses their address
+					if _, ok := prepositions[uint32(e.data[2])]; !ok {
+						prepositions[uint32(e.data[2])] = e.word
+					}
+					prepositions[e.address] = e.word
+				}
+			}
+		} else {
+			verb, isVerb = infocomWordValue(e.data, INFOCOM_PS_VERB, INFOCOM_P1_VERB)
+			if p, ok := infocomWordValue(e.data, INFOCOM_PS_PREPOSITION, INFOCOM_P1_OBJECT); ok {
+				if _, ok := prepositions[uint32(p)]; !ok {
+					prepositions[uint32(p)] = e.word
+				}
+			}
+		}
+		if isVerb {
+			verbs[verb] = append(verbs[verb], e.word)
+			if int(verb) < lowest {
+				lowest = int(verb)
+			}
+		}
+	}
+	if len(verbs) == 0 {
+		return nil, errors.New("zmachine: no verbs in the dictionary")
+	}
+	numVerbs := uint32(256 - lowest)
+
+	formats := []int{GRAMMAR_INFOCOM}
+	if inform {
+		formats = []int{GRAMMAR_INFORM_GV2, GRAMMAR_INFORM_GV1}
+	}
+	end := zm.header.dictAddress
+	if end > uint32(len(zm.buf)) {
+		end = uint32(len(zm.buf))
+	}
+
+	for _, format := range formats {
+		r := &grammarReader{zm: zm, format: format, verbs: verbs, prepositions: prepositions}
+		candidates := r.candidates(end, numVerbs)
+		if inform {
+			// Where Inform puts it
+			candidates = append([]uint32{zm.header.staticMemAddress}, candidates...)
+		}
+		for _, addr := range candidates {
+			if g := r.read(addr, numVerbs); g != nil {
+				return g, nil
+			}
+		}
+	}
+	return nil, errors.New("zmachine: grammar table not found")
+}
+
+// Addresses below end starting numVerbs words that all point to blocks
+// that decode, in one pass from the end: each block is decoded once
+func (r *grammarReader) candidates(end uint32, numVerbs uint32) []uint32 {
+	if end < HEADER_SIZE+2 {
+		return nil
+	}
+	size := uint32(len(r.zm.buf))
+	decodes := make(map[uint32]bool)
+	// Valid words from each address on, every other byte
+	run := make([]uint32, end+2)
+	var found []uint32
+	for addr := end - 2; addr >= HEADER_SIZE; addr-- {
+		block := uint32(r.zm.GetUint16(addr))
+		ok, seen := decodes[block]
+		if !seen {
+			if block >= HEADER_SIZE && block < size {
+				_, _, ok = r.readBlock(block, "")
+			}
+			decodes[block] = ok
+		}
+		if ok {
+			run[addr] = run[addr+2] + 1
+		}
+		if run[addr] >= numVerbs {
+			found = append(found, addr)
+		}
+	}
+	// Lowest first
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found
+}
+
+type grammarBlock struct {
+	start, end uint32
+}
+
+// Decodes the verb table at addr, nil if it isn't one
+func (r *grammarReader) read(addr uint32, numVerbs uint32) *Grammar {
+	size := uint32(len(r.zm.buf))
+	g := &Grammar{Format: r.format, Address: addr}
+	blocks := make([]grammarBlock, 0, numVerbs)
+
+	for i := uint32(0); i < numVerbs; i++ {
+		block := uint32(r.zm.GetUint16(addr + i*2))
+		if block < HEADER_SIZE || block >= size {
+			return nil
+		}
+		number := uint8(255 - i)
+		words := r.verbs[number]
+		verb := "?"
+		if len(words) > 0 {
+			verb = words[0]
+		}
+
+		syntaxes, blockEnd, ok := r.readBlock(block, verb)
+		if !ok {
+			return nil
+		}
+		blocks = append(blocks, grammarBlock{block, blockEnd})
+		if len(words) > 0 {
+			g.Verbs = append(g.Verbs, GrammarVerb{Number: number, Words: words, Syntaxes: syntaxes})
+		}
+	}
+
+	// Blocks never overlap, nor the table itself
+	blocks = append(blocks, grammarBlock{addr, addr + numVerbs*2})
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].start < blocks[j].start })
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i].start < blocks[i-1].end {
+			return nil
+		}
+	}
+	return g
+}
+
+func (r *grammarReader) readBlock(addr uint32, verb string) ([]GrammarSyntax, uint32, bool) {
+	size := uint32(len(r.zm.buf))
+	count := uint32(r.zm.GetUint8(addr))
+	if count == 0 || count > MAX_GRAMMAR_LINES {
+		return nil, 0, false
+	}
+	addr++
+
+	syntaxes := make([]GrammarSyntax, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var s GrammarSyntax
+		var ok bool
+		switch r.format {
+		case GRAMMAR_INFOCOM:
+			if addr+8 > size {
+				return nil, 0, false
+			}
+			s, ok = r.infocomSyntax(addr, verb)
+			addr += 8
+		case GRAMMAR_INFORM_GV1:
+			if addr+8 > size {
+				return nil, 0, false
+			}
+			s, ok = r.gv1Syntax(addr, verb)
+			addr += 8
+		case GRAMMAR_INFORM_GV2:
+			s, addr, ok = r.gv2Syntax(addr, verb)
+		}
+		if !ok {
+			return nil, 0, false
+		}
+		syntaxes = append(syntaxes, s)
+	}
+	return syntaxes, addr, true
+}
+
+// Infocom syntax: objects, two prepositions, two GWIM attributes,
+// two search options and the action
+func (r *grammarReader) infocomSyntax(addr uint32, verb string) (GrammarSyntax, bool) {
+	zm := r.zm
+	objects := int(zm.GetUint8(addr))
+	prep1 := uint32(zm.GetUint8(addr + 1))
+	prep2 := uint32(zm.GetUint8(addr + 2))
+	if objects > 2 {
+		return GrammarSyntax{}, false
+	}
+
+	words := []string{verb}
+	for n, prep := range []uint32{prep1, prep2} {
+		if prep != 0 {
+			word, ok := r.prepositions[prep]
+			if !ok {
+				return GrammarSyntax{}, false
+			}
+			words = append(words, word)
+		}
+		if objects > n {
+			words = append(words, TEMPLATE_OBJECT)
+		}
+	}
+	return GrammarSyntax{
+		Action:   int(zm.GetUint8(addr + 7)),
+		Template: strings.Join(words, " "),
+		Objects:  objects,
+	}, true
+}
+
+// Inform grammar version 1: number of parameters, up to six tokens
+// ended by 15, the action
+func (r *grammarReader) gv1Syntax(addr uint32, verb string) (GrammarSyntax, bool) {
+	zm := r.zm
+	s := GrammarSyntax{Action: int(zm.GetUint8(addr + 7))}
+	words := []string{verb}
+
+	for i := uint32(1); i <= 6; i++ {
+		token := zm.GetUint8(addr + i)
+		switch {
+		case token == 15:
+			i = 6
+			continue
+		case token <= 6, token >= 16 && token < 48, token >= 80 && token < 128:
+			// noun, held, multi..., creature, noun=Routine, scope=Routine, attributes
+			words = append(words, TEMPLATE_OBJECT)
+			s.Objects++
+		case token == 7, token == 8, token >= 48 && token < 80:
+			// special, number, parsing routines
+			words = append(words, "...")
+			s.Special = true
+		case token >= 128:
+			word, ok := r.prepositions[uint32(token)]
+			if !ok {
+				return GrammarSyntax{}, false
+			}
+			words = append(words, word)
+		default:
+			return GrammarSyntax{}, false
+		}
+	}
+	if int(zm.GetUint8(addr)) > 6 {
+		return GrammarSyntax{}, false
+	}
+	s.Template = strings.Join(words, " ")
+	return s, true
+}
+
+// Inform grammar version 2: action word, then tokens of a type byte
+// and a data word, ended by 15
+func (r *grammarReader) gv2Syntax(addr uint32, verb string) (GrammarSyntax, uint32, bool) {
+	zm := r.zm
+	size := uint32(len(zm.buf))
+	if addr+3 > size {
+		return GrammarSyntax{}, 0, false
+	}
+	s := GrammarSyntax{Action: int(zm.GetUint16(addr) & 0x3FF)}
+	words := []string{verb}
+	addr += 2
+
+	for n := 0; ; n++ {
+		if addr >= size || n > 32 {
+			return GrammarSyntax{}, 0, false
+		}
+		kind := zm.GetUint8(addr)
+		if kind == 15 {
+			addr++
+			break
+		}
+		if addr+3 > size {
+			return GrammarSyntax{}, 0, false
+		}
+		data := zm.GetUint16(addr + 1)
+		addr += 3
+
+		// Only the first of alternatives ("in"/"into") makes the template
+		if kind&0x20 != 0 {
+			continue
+		}
+		switch kind & 0x0F {
+		case 1:
+			if data <= 6 {
+				words = append(words, TEMPLATE_OBJECT)
+				s.Objects++
+			} else if data <= 9 {
+				// special, number, topic
+				words = append(words, "...")
+				s.Special = true
+			} else {
+				return GrammarSyntax{}, 0, false
+			}
+		case 2:
+			word, ok := r.prepositions[uint32(data)]
+			if !ok {
+				return GrammarSyntax{}, 0, false
+			}
+			words = append(words, word)
+		case 3, 4, 5:
+			// noun=Routine, attribute, scope=Routine
+			words = append(words, TEMPLATE_OBJECT)
+			s.Objects++
+		case 6:
+			words = append(words, "...")
+			s.Special = true
+		default:
+			return GrammarSyntax{}, 0, false
+		}
+	}
+	s.Template = strings.Join(words, " ")
+	return s, addr, true
+}
+
+// Templates lists the distinct templates without special tokens.
+func (g *Grammar) Templates() []string {
+	var templates []string
+	seen := make(map[string]bool)
+	for _, v := range g.Verbs {
+		for _, s := range v.Syntaxes {
+			if !s.Special && !seen[s.Template] {
+				seen[s.Template] = true
+				templates = append(templates, s.Template)
+			}
+		}
+	}
+	return templates
+}
+
+// Commands fills the templates with every ordering of distinct nouns.
+func (g *Grammar) Commands(nouns []string) []string {
+	var commands []string
+	for _, t := range g.Templates() {
+		commands = fillTemplate(commands, t, nouns, nil)
+	}
+	return commands
+}
+
+func fillTemplate(commands []string, template string, nouns []string, used []string) []string {
+	i := strings.Index(template, TEMPLATE_OBJECT)
+	if i < 0 {
+		return append(commands, template)
+	}
+next:
+	for _, noun := range nouns {
+		for _, u := range used {
+			if u == noun {
+				continue next
+			}
+		}
+		filled := template[:i] + noun + template[i+len(TEMPLATE_OBJECT):]
+		commands = fillTemplate(commands, filled, nouns, append(used, noun))
+	}
+	return commands
+}
+
+// ObjectsInScope lists what the player can likely refer to: everything
+// inside the current location, at any depth.
+func (zm *ZMachine) ObjectsInScope() []uint16 {
+	location := zm.WorldState().Location
+	if location == NULL_OBJECT_INDEX {
+		return nil
+	}
+	var objects []uint16
+	var walk func(parent uint16, depth int)
+	walk = func(parent uint16, depth int) {
+		// A corrupted tree could loop
+		if depth > MAX_OBJECT {
+			return
+		}
+		for obj := zm.GetFirstChild(parent); obj != NULL_OBJECT_INDEX; obj = zm.GetSibling(obj) {
+			objects = append(objects, obj)
+			walk(obj, depth+1)
+		}
+	}
+	walk(location, 0)
+	return objects
+}
+
+// ObjectNoun returns a dictionary word for the object: the last word of
+// its name the game knows, or "" if none.
+func (zm *ZMachine) ObjectNoun(obj uint16) string {
+	words := strings.Fields(strings.ToLower(zm.GetObjectName(obj)))
+	for i := len(words) - 1; i >= 0; i-- {
+		if zm.FindInDictionary(words[i]) != DICT_NOT_FOUND {
+			return words[i]
+		}
+	}
+	return ""
+}
+
+// PlausibleCommands fills the grammar templates with the nouns of the
+// objects in scope.
+func (zm *ZMachine) PlausibleCommands() (commands []string, err error) {
+	defer zm.recoverError(&err)
+	g, err := zm.Grammar()
+	if err != nil {
+		return nil, err
+	}
+	var nouns []string
+	seen := make(map[string]bool)
+	for _, obj := range zm.ObjectsInScope() {
+		if noun := zm.ObjectNoun(obj); noun != "" && !seen[noun] {
+			seen[noun] = true
+			nouns = append(nouns, noun)
+		}
+	}
+	return g.Commands(nouns), nil
+}
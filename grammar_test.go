@@ -0,0 +1,41 @@
+package zmachine
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGrammar(t *testing.T) {
+	zm := miniMachine(t)
+	g, err := zm.Grammar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Format != GRAMMAR_INFOCOM || len(g.Verbs) != 6 {
+		t.Fatalf("format %d, %d verbs", g.Format, len(g.Verbs))
+	}
+	if look := g.Verbs[0]; look.Number != 255 || !reflect.DeepEqual(look.Words, []string{"look"}) ||
+		len(look.Syntaxes) != 2 || look.Syntaxes[1].Action != 2 || look.Syntaxes[1].Objects != 1 {
+		t.Errorf("look %+v", look)
+	}
+
+	// Words are cut to six letters in version 3
+	templates := g.Templates()
+	sort.Strings(templates)
+	want := []string{"look", "look at OBJ", "quit", "restar", "restor", "save", "take OBJ"}
+	if !reflect.DeepEqual(templates, want) {
+		t.Errorf("templates %q, want %q", templates, want)
+	}
+
+	// The player is in scope too, but has no noun the game knows
+	commands, err := zm.PlausibleCommands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(commands)
+	want = []string{"look", "look at lamp", "quit", "restar", "restor", "save", "take lamp"}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands %q, want %q", commands, want)
+	}
+}
@@ -0,0 +1,177 @@
+// Package gym wraps a story in a reinforcement learning environment,
+// in the style of Jericho: Reset starts a game, Step plays a command and
+// reports the score change, and GetState/SetState allow backtracking.
+// With the same seed and the same commands, a game always plays the same.
+package gym
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/awgh/zmachine"
+)
+
+var (
+	ErrGameOver   = errors.New("gym: the game is over")
+	ErrNotStarted = errors.New("gym: no game, call Reset first")
+	ErrNoState    = errors.New("gym: no state, call GetState first")
+)
+
+// World state after a step
+type Info struct {
+	Location  string
+	Inventory []string
+	Score     int
+	Moves     int
+}
+
+type Env struct {
+	// Player object, whose children are the inventory. 0 = as the
+	// game's profile says, see zmachine.WorldState.
+	Player uint16
+	// Header configuration, zmachine.DefaultConfig() by default
+	Config zmachine.Config
+	// Turn limits, zmachine.DefaultLimits() by default
+	Limits zmachine.Limits
+
+	story  []byte
+	zm     *zmachine.ZMachine
+	output bytes.Buffer
+	score  int
+}
+
+// Snapshot of a game for SetState
+type State struct {
+	zm    *zmachine.ZMachine
+	score int
+}
+
+// New checks the story; call Reset to start playing.
+func New(story []byte) (*Env, error) {
+	story, err := zmachine.StoryBytes(story)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Env{
+		Config: zmachine.DefaultConfig(),
+		Limits: zmachine.DefaultLimits(),
+		story:  story,
+	}, nil
+}
+
+// Reset starts the game over with the random generator seeded with seed,
+// and returns the text up to the first prompt.
+func (e *Env) Reset(seed int64) (string, Info, error) {
+	zm, err := zmachine.LoadBytes(e.story)
+	if err != nil {
+		return "", Info{}, err
+	}
+	zm.Configure(e.Config)
+	zm.Limits = e.Limits
+	zm.SeedRandom(seed)
+	zm.SetOutput(&e.output)
+	zm.Storage = &zmachine.MemoryStorage{}
+	if e.Player != 0 {
+		profile := zm.ActiveProfile()
+		profile.Player = e.Player
+		zm.Profile = &profile
+	}
+
+	e.zm = zm
+	e.score = 0
+	e.output.Reset()
+
+	obs, err := e.run()
+	e.score = e.Info().Score
+	return obs, e.Info(), err
+}
+
+// Step plays one command. The reward is the change of score.
+func (e *Env) Step(action string) (obs string, reward int, done bool, info Info, err error) {
+	if e.zm == nil {
+		return "", 0, true, Info{}, ErrNotStarted
+	}
+	if e.zm.Done {
+		return "", 0, true, e.Info(), ErrGameOver
+	}
+
+	if e.zm.Waiting().Kind == zmachine.INPUT_CHAR {
+		key := uint16(13)
+		if action != "" {
+			key = uint16(action[0])
+		}
+		err = e.zm.SendKey(key)
+	} else {
+		err = e.zm.SendLine(action)
+	}
+	if err == nil {
+		obs, err = e.run()
+	}
+
+	info = e.Info()
+	reward = info.Score - e.score
+	e.score = info.Score
+	return obs, reward, e.zm.Done, info, err
+}
+
+func (e *Env) run() (string, error) {
+	_, err := e.zm.RunUntilInput()
+	obs := e.output.String()
+	e.output.Reset()
+	return obs, err
+}
+
+// Info reports where the player is, what they carry, the score and moves.
+func (e *Env) Info() Info {
+	if e.zm == nil {
+		return Info{}
+	}
+	ws := e.zm.WorldState()
+	return Info{
+		Location:  ws.LocationName,
+		Inventory: ws.InventoryNames,
+		Score:     int(ws.Score),
+		Moves:     int(ws.Moves),
+	}
+}
+
+// ValidActions returns the candidates that change the world state,
+// tried on copies of the game.
+func (e *Env) ValidActions(candidates []string) ([]string, error) {
+	if e.zm == nil {
+		return nil, ErrNotStarted
+	}
+	if e.zm.Done {
+		return nil, ErrGameOver
+	}
+	return e.zm.ValidActions(candidates)
+}
+
+// The underlying machine, for anything the environment doesn't cover
+func (e *Env) Machine() *zmachine.ZMachine {
+	return e.zm
+}
+
+// GetState snapshots the game, random generator and the game's own
+// saved game included.
+func (e *Env) GetState() (*State, error) {
+	if e.zm == nil {
+		return nil, ErrNotStarted
+	}
+	// Clone copies the MemoryStorage, later saves don't change the snapshot
+	return &State{zm: e.zm.Clone(), score: e.score}, nil
+}
+
+// SetState goes back to a snapshot; the snapshot itself stays unchanged
+// and can be used again.
+func (e *Env) SetState(s *State) error {
+	if s == nil || s.zm == nil {
+		return ErrNoState
+	}
+	e.zm = s.zm.Clone()
+	e.zm.SetOutput(&e.output)
+	e.score = s.score
+	e.output.Reset()
+	return nil
+}
@@ -0,0 +1,153 @@
+package gym
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/awgh/zmachine/zasm"
+)
+
+// Every command rolls a die and adds it to the score
+const dice = `
+.version 3
+.global location 0
+.global score 0
+.global moves 0
+.global roll 0
+.space textbuf 20
+.space parsebuf 10
+.routine main
+    storeb textbuf 0 18
+    storeb parsebuf 0 2
+turn:
+    print ">"
+    sread textbuf parsebuf
+    random 100 -> roll
+    print_num roll
+    new_line
+    add score roll -> score
+    inc moves
+    jump turn
+`
+
+func newEnv(t *testing.T, src string) *Env {
+	story, err := zasm.Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func miniEnv(t *testing.T) *Env {
+	src, err := ioutil.ReadFile(filepath.Join("..", "bench", "stories", "mini.zas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newEnv(t, string(src))
+}
+
+type episode struct {
+	observations []string
+	rewards      []int
+	hashes       []uint64
+}
+
+func play(t *testing.T, e *Env, seed int64, actions []string) *episode {
+	obs, _, err := e.Reset(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &episode{observations: []string{obs}}
+	for _, action := range actions {
+		obs, reward, _, _, err := e.Step(action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ep.observations = append(ep.observations, obs)
+		ep.rewards = append(ep.rewards, reward)
+		ep.hashes = append(ep.hashes, e.Machine().StateHash(e.Machine().VolatileGlobals()...))
+	}
+	return ep
+}
+
+func TestDeterminism(t *testing.T) {
+	actions := []string{"roll", "roll", "roll", "roll", "roll"}
+	first := play(t, newEnv(t, dice), 42, actions)
+	second := play(t, newEnv(t, dice), 42, actions)
+
+	for i := range first.observations {
+		if first.observations[i] != second.observations[i] {
+			t.Errorf("observation %d: %q, then %q", i, first.observations[i], second.observations[i])
+		}
+	}
+	for i := range first.rewards {
+		if first.rewards[i] != second.rewards[i] || first.hashes[i] != second.hashes[i] {
+			t.Errorf("step %d: reward %d and hash %x, then %d and %x", i,
+				first.rewards[i], first.hashes[i], second.rewards[i], second.hashes[i])
+		}
+	}
+
+	// Another seed, other rolls
+	other := play(t, newEnv(t, dice), 43, actions)
+	same := true
+	for i := range first.rewards {
+		same = same && first.rewards[i] == other.rewards[i]
+	}
+	if same {
+		t.Errorf("seeds 42 and 43 rolled the same: %v", first.rewards)
+	}
+}
+
+func TestGetStateBeforeReset(t *testing.T) {
+	e := newEnv(t, dice)
+	if _, err := e.GetState(); err != ErrNotStarted {
+		t.Errorf("GetState before Reset: %v, want ErrNotStarted", err)
+	}
+	if _, _, _, _, err := e.Step("roll"); err != ErrNotStarted {
+		t.Errorf("Step before Reset: %v, want ErrNotStarted", err)
+	}
+	if _, err := e.ValidActions([]string{"roll"}); err != ErrNotStarted {
+		t.Errorf("ValidActions before Reset: %v, want ErrNotStarted", err)
+	}
+	if err := e.SetState(nil); err != ErrNoState {
+		t.Errorf("SetState(nil): %v, want ErrNoState", err)
+	}
+}
+
+// A snapshot keeps the random generator and its own saved game
+func TestState(t *testing.T) {
+	e := newEnv(t, dice)
+	e.Reset(1)
+	s, err := e.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roll, _, _, _, _ := e.Step("roll")
+	if err := e.SetState(s); err != nil {
+		t.Fatal(err)
+	}
+	if again, _, _, _, _ := e.Step("roll"); again != roll {
+		t.Errorf("rolled %q after SetState, %q before", again, roll)
+	}
+
+	e = miniEnv(t)
+	e.Reset(1)
+	e.Step("save")
+	s, err = e.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Step("north")
+	e.Step("save")
+	if err := e.SetState(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, info, _ := e.Step("restore"); info.Location != "West of House" {
+		t.Errorf("restored to %q, want the snapshot's saved game in West of House", info.Location)
+	}
+}
@@ -0,0 +1,63 @@
+package zmachine
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+)
+
+// VolatileGlobals lists the globals that change every turn whatever the
+// player does: the moves, or minutes in a time game, of the active
+// profile.
+func (zm *ZMachine) VolatileGlobals() []uint8 {
+	if moves := zm.ActiveProfile().MovesGlobal; moves >= 0x10 {
+		return []uint8{moves}
+	}
+	return nil
+}
+
+// StateHash hashes dynamic memory, the stack and the instruction pointer,
+// so machines in the same state hash the same. The excluded globals
+// (e.g. VolatileGlobals()) count as zero, letting states that differ
+// only by them be recognised as the same world state.
+func (zm *ZMachine) StateHash(exclude ...uint8) uint64 {
+	h := fnv.New64a()
+
+	// Global addresses within dynamic memory, in order
+	var skip []uint32
+	for _, v := range exclude {
+		if v >= 0x10 {
+			skip = append(skip, zm.header.globalVarAddress+uint32(v-0x10)*2)
+		}
+	}
+	sort.Slice(skip, func(i, j int) bool { return skip[i] < skip[j] })
+
+	zero := []uint8{0, 0}
+	start := uint32(0)
+	end := uint32(len(zm.dynMem))
+	for _, addr := range skip {
+		if addr < start || addr+2 > end {
+			continue
+		}
+		h.Write(zm.dynMem[start:addr])
+		h.Write(zero)
+		start = addr + 2
+	}
+	h.Write(zm.dynMem[start:])
+
+	var word [4]uint8
+	for _, v := range zm.stack.stack[zm.stack.top:] {
+		binary.BigEndian.PutUint16(word[:2], v)
+		h.Write(word[:2])
+	}
+	binary.BigEndian.PutUint32(word[:], uint32(zm.stack.top))
+	h.Write(word[:])
+	binary.BigEndian.PutUint32(word[:], uint32(zm.stack.localFrame))
+	h.Write(word[:])
+	binary.BigEndian.PutUint32(word[:], uint32(zm.stack.numLocals))
+	h.Write(word[:])
+	binary.BigEndian.PutUint32(word[:], zm.ip)
+	h.Write(word[:])
+
+	return h.Sum64()
+}
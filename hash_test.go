@@ -0,0 +1,48 @@
+package zmachine
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestStateHash(t *testing.T) {
+	zm := miniMachine(t)
+	waited := zm.Clone()
+	waited.SetOutput(ioutil.Discard)
+	play(t, zm, "take lamp")
+	volatile := zm.VolatileGlobals()
+	before, full := zm.StateHash(volatile...), zm.StateHash()
+
+	// look only counts a move, and the parser's verb is the same again
+	play(t, zm, "look", "take lamp")
+	if zm.StateHash(volatile...) != before {
+		t.Error("moves changed the hash without moves")
+	}
+	if zm.StateHash() == full {
+		t.Error("moves didn't change the full hash")
+	}
+
+	play(t, waited, "take lamp")
+	if waited.StateHash(volatile...) != before {
+		t.Error("same game, different hash")
+	}
+	play(t, waited, "north")
+	if waited.StateHash(volatile...) == before {
+		t.Error("moving didn't change the hash")
+	}
+}
+
+func TestVolatileGlobals(t *testing.T) {
+	zm := miniMachine(t)
+	if v := zm.VolatileGlobals(); len(v) != 1 || v[0] != 0x12 {
+		t.Errorf("default profile: %v", v)
+	}
+	zm.Profile = &GameProfile{MovesGlobal: 0x30}
+	if v := zm.VolatileGlobals(); len(v) != 1 || v[0] != 0x30 {
+		t.Errorf("moves in 0x30: %v", v)
+	}
+	zm.Profile = &GameProfile{}
+	if v := zm.VolatileGlobals(); v != nil {
+		t.Errorf("no moves: %v", v)
+	}
+}
@@ -0,0 +1,341 @@
+// Package httpapi plays Z-machine games through a turn-based HTTP/JSON API,
+// for web pages and chat bots:
+//
+//	POST   /sessions               {"story": "zork1", "seed": 0}  start a game
+//	POST   /sessions/{id}/commands {"command": "open mailbox"}    play a turn
+//	GET    /sessions/{id}                                         transcript so far
+//	DELETE /sessions/{id}                                         end the game
+//
+// Starting a game and playing a turn both answer with the text printed
+// until the game waits for the next line, the status line and whether
+// the game is over.
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awgh/zmachine"
+)
+
+type API struct {
+	// Games are dropped after this long without a command, 0 = never
+	SessionTimeout time.Duration
+	// Maximum number of games in progress, 0 = no limit
+	MaxSessions int
+	// Header configuration for every machine, zmachine.DefaultConfig() by default
+	Config zmachine.Config
+	// A game going over these in a turn is stopped, zmachine.DefaultLimits() by default
+	Limits zmachine.Limits
+
+	mu       sync.Mutex
+	stories  map[string][]byte
+	sessions map[string]*session
+	// Turns run on this rather than the request's context, so a client
+	// going away mid-turn doesn't end its game. Close cancels it and
+	// makes a new one.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New() *API {
+	config := zmachine.DefaultConfig()
+	// Clients draw the status line from the JSON
+	config.StatusLine = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &API{
+		Config:   config,
+		Limits:   zmachine.DefaultLimits(),
+		stories:  make(map[string][]byte),
+		sessions: make(map[string]*session),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// AddStory makes a story available to new sessions under name.
+func (api *API) AddStory(name string, story []byte) error {
+	story, err := zmachine.StoryBytes(story)
+	if err != nil {
+		return err
+	}
+
+	api.mu.Lock()
+	api.stories[name] = story
+	api.mu.Unlock()
+	return nil
+}
+
+// Number of games in progress
+func (api *API) Sessions() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.sessions)
+}
+
+// Close ends every game in progress, stopping the turns being played.
+func (api *API) Close() {
+	api.mu.Lock()
+	api.sessions = make(map[string]*session)
+	api.cancel()
+	api.ctx, api.cancel = context.WithCancel(context.Background())
+	api.mu.Unlock()
+}
+
+// Answer to starting a game and to every command
+type Turn struct {
+	ID     string              `json:"id"`
+	Output string              `json:"output"`
+	Status zmachine.StatusLine `json:"status"`
+	// What the game waits for: "line", "char" (answered with the first
+	// character of the next command) or empty when it's over
+	Input string `json:"input,omitempty"`
+	Done  bool   `json:"done"`
+	// Set when the game crashed or was stopped
+	Error string `json:"error,omitempty"`
+}
+
+// Answer to GET /sessions/{id}
+type Transcript struct {
+	ID         string              `json:"id"`
+	Story      string              `json:"story"`
+	Transcript string              `json:"transcript"`
+	Status     zmachine.StatusLine `json:"status"`
+	Done       bool                `json:"done"`
+}
+
+type newSessionRequest struct {
+	Story string `json:"story"`
+	// 0 = random
+	Seed int64 `json:"seed"`
+}
+
+type commandRequest struct {
+	Command string `json:"command"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+var (
+	errNotFound = errors.New("no such session")
+	errFull     = errors.New("too many sessions, please try again later")
+	errGameOver = errors.New("the game is over")
+)
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.expire()
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "sessions" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		api.createSession(w, r)
+	case len(parts) == 2:
+		switch r.Method {
+		case http.MethodGet:
+			api.getTranscript(w, parts[1])
+		case http.MethodDelete:
+			api.deleteSession(w, parts[1])
+		default:
+			methodNotAllowed(w, http.MethodGet+", "+http.MethodDelete)
+		}
+	case parts[2] == "commands":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		api.postCommand(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (api *API) createSession(w http.ResponseWriter, r *http.Request) {
+	var req newSessionRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	api.mu.Lock()
+	story, ok := api.stories[req.Story]
+	if req.Story == "" && len(api.stories) == 1 {
+		for req.Story, story = range api.stories {
+		}
+		ok = true
+	}
+	api.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such story %q", req.Story))
+		return
+	}
+
+	zm, err := zmachine.LoadBytes(story)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	zm.Configure(api.Config)
+	zm.Limits = api.Limits
+	if req.Seed != 0 {
+		zm.SeedRandom(req.Seed)
+	}
+
+	sess := newSession(newID(), req.Story, zm)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	// Checked and added together, so that simultaneous requests can't
+	// go over the limit
+	api.mu.Lock()
+	if api.MaxSessions > 0 && len(api.sessions) >= api.MaxSessions {
+		api.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, errFull)
+		return
+	}
+	sess.ctx = api.ctx
+	api.sessions[sess.id] = sess
+	api.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, sess.turn(sess.runUntilInput()))
+}
+
+func (api *API) postCommand(w http.ResponseWriter, r *http.Request, id string) {
+	var req commandRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	// A line is a line: anything after a line break would be the next command
+	if i := strings.IndexAny(req.Command, "\r\n"); i >= 0 {
+		req.Command = req.Command[:i]
+	}
+
+	sess := api.lookup(id)
+	if sess == nil {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.done() {
+		writeError(w, http.StatusConflict, errGameOver)
+		return
+	}
+	sess.transcript.WriteString(req.Command + "\n")
+	if err := sess.send(req.Command); err != nil {
+		sess.err = err
+	}
+	writeJSON(w, http.StatusOK, sess.turn(sess.runUntilInput()))
+}
+
+func (api *API) getTranscript(w http.ResponseWriter, id string) {
+	sess := api.lookup(id)
+	if sess == nil {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, &Transcript{
+		ID:         sess.id,
+		Story:      sess.story,
+		Transcript: sess.transcript.String(),
+		Status:     sess.status,
+		Done:       sess.done(),
+	})
+}
+
+func (api *API) deleteSession(w http.ResponseWriter, id string) {
+	api.mu.Lock()
+	sess := api.sessions[id]
+	delete(api.sessions, id)
+	api.mu.Unlock()
+
+	if sess == nil {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) lookup(id string) *session {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	sess := api.sessions[id]
+	if sess != nil {
+		sess.lastUsed = time.Now()
+	}
+	return sess
+}
+
+// Drops the games nobody played for SessionTimeout
+func (api *API) expire() {
+	if api.SessionTimeout <= 0 {
+		return
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	for id, sess := range api.sessions {
+		if time.Since(sess.lastUsed) > api.SessionTimeout {
+			delete(api.sessions, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+	// An empty body is fine, every field is optional
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, &errorResponse{err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
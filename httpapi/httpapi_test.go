@@ -0,0 +1,186 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/awgh/zmachine/walkthrough"
+	"github.com/awgh/zmachine/zasm"
+)
+
+func miniStory(t *testing.T) []byte {
+	story, err := walkthrough.ReadStory(filepath.Join("..", "bench", "stories", "mini.zas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return story
+}
+
+func newAPI(t *testing.T) *API {
+	api := New()
+	if err := api.AddStory("mini", miniStory(t)); err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+// Serves a request, decoding the JSON answer into v if not nil
+func do(t *testing.T, api *API, r *http.Request, v interface{}) int {
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v in %q", r.Method, r.URL, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func request(method, path, body string) *http.Request {
+	return httptest.NewRequest(method, path, strings.NewReader(body))
+}
+
+func start(t *testing.T, api *API) *Turn {
+	var turn Turn
+	if code := do(t, api, request("POST", "/sessions", `{"story": "mini"}`), &turn); code != http.StatusCreated {
+		t.Fatalf("new session: status %d", code)
+	}
+	return &turn
+}
+
+func command(t *testing.T, api *API, id, command string) *Turn {
+	var turn Turn
+	body, _ := json.Marshal(&commandRequest{command})
+	if code := do(t, api, request("POST", "/sessions/"+id+"/commands", string(body)), &turn); code != http.StatusOK {
+		t.Fatalf("%s: status %d", command, code)
+	}
+	return &turn
+}
+
+func TestPlay(t *testing.T) {
+	api := newAPI(t)
+
+	turn := start(t, api)
+	if !strings.Contains(turn.Output, "Mini test story") || turn.Input != "line" || turn.Done {
+		t.Fatalf("first turn %+v", turn)
+	}
+	if turn.Status.Location != "West of House" {
+		t.Errorf("status %+v", turn.Status)
+	}
+
+	turn = command(t, api, turn.ID, "take lamp")
+	if !strings.Contains(turn.Output, "Taken.") || turn.Status.Score != 5 || turn.Status.Moves != 1 {
+		t.Errorf("take lamp: %+v", turn)
+	}
+
+	var transcript Transcript
+	if code := do(t, api, request("GET", "/sessions/"+turn.ID, ""), &transcript); code != http.StatusOK {
+		t.Fatalf("transcript: status %d", code)
+	}
+	if !strings.Contains(transcript.Transcript, ">take lamp\nTaken.") || transcript.Status.Score != 5 {
+		t.Errorf("transcript %+v", transcript)
+	}
+
+	turn = command(t, api, turn.ID, "quit")
+	if !turn.Done || turn.Input != "" || !strings.Contains(turn.Output, "Score: 5") {
+		t.Errorf("quit: %+v", turn)
+	}
+	if code := do(t, api, request("POST", "/sessions/"+turn.ID+"/commands", `{"command": "look"}`), nil); code != http.StatusConflict {
+		t.Errorf("command after quit: status %d", code)
+	}
+
+	if code := do(t, api, request("DELETE", "/sessions/"+turn.ID, ""), nil); code != http.StatusNoContent {
+		t.Errorf("delete: status %d", code)
+	}
+	if code := do(t, api, request("GET", "/sessions/"+turn.ID, ""), nil); code != http.StatusNotFound {
+		t.Errorf("deleted session: status %d", code)
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	api := newAPI(t)
+	api.MaxSessions = 3
+
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = do(t, api, request("POST", "/sessions", `{}`), nil)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusServiceUnavailable:
+		default:
+			t.Errorf("status %d", code)
+		}
+	}
+	if created != 3 || api.Sessions() != 3 {
+		t.Errorf("%d created, %d sessions, want 3", created, api.Sessions())
+	}
+}
+
+// A client going away mid-turn doesn't end the game
+func TestClientGone(t *testing.T) {
+	api := newAPI(t)
+	id := start(t, api).ID
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var turn Turn
+	r := request("POST", "/sessions/"+id+"/commands", `{"command": "take lamp"}`).WithContext(ctx)
+	if code := do(t, api, r, &turn); code != http.StatusOK || turn.Error != "" || turn.Done {
+		t.Fatalf("status %d, turn %+v", code, turn)
+	}
+	if turn := command(t, api, id, "take lamp"); !strings.Contains(turn.Output, "already") {
+		t.Errorf("game not carried on: %+v", turn)
+	}
+}
+
+// A status line that can't be read ends the game instead of the server
+func TestBrokenStatus(t *testing.T) {
+	story, err := zasm.Assemble(`
+.version 3
+.global location room
+.object room "Room"
+.space textbuf 20
+.space parsebuf 10
+.routine main
+    storeb textbuf 0 18
+    storeb parsebuf 0 2
+    ; the room's properties, with its name, past the end of the story
+    loadw 0 5 -> sp
+    add sp 69 -> sp
+    storew sp 0 $FFF0
+    sread textbuf parsebuf
+    quit
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := New()
+	if err := api.AddStory("broken", story); err != nil {
+		t.Fatal(err)
+	}
+
+	var turn Turn
+	if code := do(t, api, request("POST", "/sessions", `{}`), &turn); code != http.StatusCreated {
+		t.Fatalf("status %d", code)
+	}
+	if !turn.Done || turn.Error == "" {
+		t.Errorf("turn %+v", turn)
+	}
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/awgh/zmachine"
+)
+
+// A game in progress, run one turn at a time by the handlers
+type session struct {
+	id    string
+	story string
+	zm    *zmachine.ZMachine
+	// Of the API, turns are stopped when it closes
+	ctx context.Context
+
+	// Held while the machine runs a turn
+	mu         sync.Mutex
+	output     bytes.Buffer
+	transcript bytes.Buffer
+	status     zmachine.StatusLine
+	err        error
+
+	// Guarded by API.mu
+	lastUsed time.Time
+}
+
+func newSession(id, story string, zm *zmachine.ZMachine) *session {
+	sess := &session{
+		id:       id,
+		story:    story,
+		zm:       zm,
+		lastUsed: time.Now(),
+	}
+	zm.SetOutput(&sess.output)
+	zm.Storage = &zmachine.MemoryStorage{}
+	return sess
+}
+
+func (sess *session) done() bool {
+	return sess.zm.Done || sess.err != nil
+}
+
+// Lets the machine run until it asks for the next input or stops, reads
+// the status line, and returns what the game printed meanwhile. A game
+// going over its limits or crashing is over.
+func (sess *session) runUntilInput() string {
+	if _, err := sess.zm.Run(sess.ctx); err != nil {
+		sess.err = err
+	} else if sess.status, err = sess.zm.ReadStatus(); err != nil {
+		sess.err = err
+	}
+
+	output := sess.output.String()
+	sess.output.Reset()
+	sess.transcript.WriteString(output)
+	return output
+}
+
+// Answers the pending input request. Games waiting for a single key
+// get the first character of the command, or Enter.
+func (sess *session) send(command string) error {
+	if sess.zm.Waiting().Kind == zmachine.INPUT_CHAR {
+		key := uint16(13)
+		if command != "" {
+			key = uint16(command[0])
+		}
+		return sess.zm.SendKey(key)
+	}
+	return sess.zm.SendLine(command)
+}
+
+func (sess *session) turn(output string) *Turn {
+	turn := &Turn{
+		ID:     sess.id,
+		Output: output,
+		Status: sess.status,
+		Done:   sess.done(),
+	}
+	switch sess.zm.Waiting().Kind {
+	case zmachine.INPUT_LINE:
+		turn.Input = "line"
+	case zmachine.INPUT_CHAR:
+		turn.Input = "char"
+	}
+	if sess.err != nil {
+		turn.Error = sess.err.Error()
+	}
+	return turn
+}
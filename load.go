@@ -0,0 +1,148 @@
+package zmachine
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const HEADER_SIZE = 0x40
+
+// Load reads a story file and returns a machine ready to run it.
+func Load(r io.Reader) (*ZMachine, error) {
+	buffer, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return LoadBytes(buffer)
+}
+
+// LoadFile loads the story file at path.
+func LoadFile(path string) (*ZMachine, error) {
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	zm, err := LoadBytes(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return zm, nil
+}
+
+// LoadBytes validates a story file image and returns a machine running it.
+// The image is never modified, so several machines can share it.
+// Blorb files are accepted too, their Z-code resource is loaded.
+func LoadBytes(buffer []uint8) (*ZMachine, error) {
+	if IsBlorb(buffer) {
+		return LoadBlorb(buffer)
+	}
+
+	var header ZHeader
+	if err := header.Validate(buffer); err != nil {
+		return nil, err
+	}
+
+	zm := new(ZMachine)
+	zm.Initialize(buffer, header)
+	return zm, nil
+}
+
+// Validate reads the header from buf and checks that it describes
+// a story this interpreter can run and that its tables fit in the file.
+func (h *ZHeader) Validate(buf []byte) error {
+	if len(buf) < HEADER_SIZE {
+		return fmt.Errorf("story file too short (%d bytes), not even a header", len(buf))
+	}
+	h.Read(buf)
+
+	if h.Version != 3 {
+		return fmt.Errorf("unsupported story file version %d, only version 3 is supported", h.Version)
+	}
+	size := uint32(len(buf))
+	if h.fileLength != 0 && h.fileLength > size {
+		return fmt.Errorf("story file truncated: header says %d bytes, file has %d", h.fileLength, size)
+	}
+	if h.staticMemAddress < HEADER_SIZE || h.staticMemAddress > size {
+		return fmt.Errorf("static memory base 0x%X outside of the file", h.staticMemAddress)
+	}
+	if uint32(h.hiMemBase) < h.staticMemAddress || uint32(h.hiMemBase) > size {
+		return fmt.Errorf("high memory base 0x%X not between static memory (0x%X) and end of file",
+			h.hiMemBase, h.staticMemAddress)
+	}
+	if uint32(h.ip) < HEADER_SIZE || uint32(h.ip) >= size {
+		return fmt.Errorf("initial PC 0x%X outside of the file", h.ip)
+	}
+
+	// Tables written by the game must be in dynamic memory
+	if h.globalVarAddress < HEADER_SIZE || h.globalVarAddress+240*2 > h.staticMemAddress {
+		return fmt.Errorf("global variables at 0x%X not in dynamic memory", h.globalVarAddress)
+	}
+	if h.objTableAddress < HEADER_SIZE || h.objTableAddress+31*2 > h.staticMemAddress {
+		return fmt.Errorf("object table at 0x%X not in dynamic memory", h.objTableAddress)
+	}
+	if h.abbreviationTable < HEADER_SIZE || h.abbreviationTable+96*2 > size {
+		return fmt.Errorf("abbreviation table at 0x%X outside of the file", h.abbreviationTable)
+	}
+	if h.dictAddress < HEADER_SIZE || h.dictAddress >= size {
+		return fmt.Errorf("dictionary at 0x%X outside of the file", h.dictAddress)
+	}
+	numSeparators := uint32(buf[h.dictAddress])
+	if h.dictAddress+1+numSeparators+3 > size {
+		return fmt.Errorf("dictionary header at 0x%X truncated", h.dictAddress)
+	}
+	entryLength := uint32(buf[h.dictAddress+1+numSeparators])
+	numEntries := uint32(GetUint16(buf, h.dictAddress+1+numSeparators+1))
+	if entryLength < 4 && numEntries > 0 {
+		return fmt.Errorf("dictionary entries of %d bytes are too short", entryLength)
+	}
+	if h.dictAddress+1+numSeparators+3+entryLength*numEntries > size {
+		return fmt.Errorf("dictionary at 0x%X (%d entries) runs past the end of the file",
+			h.dictAddress, numEntries)
+	}
+
+	return nil
+}
+
+// StoryBytes returns the Z-code in data, unwrapping it from a Blorb
+// file if needed, after checking that LoadBytes accepts it. Front ends
+// call it once and share the result read-only between machines.
+func StoryBytes(data []byte) ([]byte, error) {
+	if IsBlorb(data) {
+		blorb, err := ReadBlorb(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = blorb.StoryFile(); err != nil {
+			return nil, err
+		}
+	}
+	var header ZHeader
+	if err := header.Validate(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// LoadBlorb loads the Z-code stored in a Blorb file. The other resources
+// are available through ZMachine.Blorb.
+func LoadBlorb(buffer []uint8) (*ZMachine, error) {
+	blorb, err := ReadBlorb(buffer)
+	if err != nil {
+		return nil, err
+	}
+	story, err := blorb.StoryFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var header ZHeader
+	if err := header.Validate(story); err != nil {
+		return nil, err
+	}
+
+	zm := new(ZMachine)
+	zm.Initialize(story, header)
+	zm.blorb = blorb
+	return zm, nil
+}
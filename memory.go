@@ -0,0 +1,176 @@
+package zmachine
+
+import "fmt"
+
+// Highest byte address: dynamic and static memory end below 64K, high
+// memory beyond is only reached through packed addresses
+const MAX_BYTE_ADDRESS = 0xFFFF
+
+// The only header bytes the game may change (Flags 2)
+const (
+	HEADER_GAME_WRITABLE_START = 0x10
+	HEADER_GAME_WRITABLE_END   = 0x12
+)
+
+// Memory regions of the standard (1.1)
+type MemoryRegion uint8
+
+const (
+	MEMORY_DYNAMIC MemoryRegion = iota
+	MEMORY_STATIC
+	MEMORY_HIGH
+	// Past the end of the story file
+	MEMORY_OUTSIDE
+)
+
+func (r MemoryRegion) String() string {
+	switch r {
+	case MEMORY_DYNAMIC:
+		return "dynamic"
+	case MEMORY_STATIC:
+		return "static"
+	case MEMORY_HIGH:
+		return "high"
+	}
+	return "outside"
+}
+
+// A game access to memory its region doesn't allow
+type MemoryError struct {
+	// Start of the instruction that made it
+	IP      uint32
+	Address uint32
+	Region  MemoryRegion
+	Write   bool
+}
+
+func (e *MemoryError) Error() string {
+	access := "read from"
+	if e.Write {
+		access = "write to"
+	}
+	where := "in " + e.Region.String() + " memory"
+	switch {
+	case e.Region == MEMORY_OUTSIDE:
+		where = "past the end of memory"
+	case e.Write && e.Address < HEADER_SIZE:
+		where = "in the header"
+	}
+	return fmt.Sprintf("zmachine: %s 0x%X %s (IP 0x%X)", access, e.Address, where, e.IP)
+}
+
+// Region of a byte address. High memory may overlap static memory,
+// below 64K it counts as static.
+func (zm *ZMachine) Region(address uint32) MemoryRegion {
+	switch {
+	case address < zm.header.staticMemAddress:
+		return MEMORY_DYNAMIC
+	case address >= uint32(len(zm.buf)):
+		return MEMORY_OUTSIDE
+	case address <= MAX_BYTE_ADDRESS:
+		return MEMORY_STATIC
+	}
+	return MEMORY_HIGH
+}
+
+func (zm *ZMachine) memoryError(address uint32, write bool) *MemoryError {
+	return &MemoryError{IP: zm.instructionStart, Address: address, Region: zm.Region(address), Write: write}
+}
+
+// Out of line, so that the checked accesses stay cheap to inline
+func (zm *ZMachine) memoryViolation(address uint32, write bool) {
+	zm.violation(zm.memoryError(address, write))
+}
+
+// Checked accesses of the game by byte address, for opcodes and the
+// tables they read and write. Dynamic and static memory can be read;
+// only dynamic memory, and of the header only Flags 2, written. Unless
+// strict, a bad read gives 0 and a bad write is dropped.
+
+func (zm *ZMachine) LoadByte(address uint32) uint8 {
+	if address > MAX_BYTE_ADDRESS || address >= uint32(len(zm.buf)) {
+		zm.memoryViolation(address, false)
+		return 0
+	}
+	return zm.GetUint8(address)
+}
+
+func (zm *ZMachine) LoadWord(address uint32) uint16 {
+	return (uint16(zm.LoadByte(address)) << 8) | uint16(zm.LoadByte(address+1))
+}
+
+func (zm *ZMachine) StoreByte(address uint32, v uint8) {
+	// Past the header, in dynamic memory
+	if address >= HEADER_SIZE && address < uint32(len(zm.dynMem)) {
+		zm.dynMem[address] = v
+	} else {
+		zm.storeHeaderByte(address, v)
+	}
+}
+
+// Flags 2, or a violation
+func (zm *ZMachine) storeHeaderByte(address uint32, v uint8) {
+	if address < HEADER_GAME_WRITABLE_START || address >= HEADER_GAME_WRITABLE_END || !zm.IsSafeToWrite(address) {
+		zm.memoryViolation(address, true)
+		return
+	}
+	zm.dynMem[address] = v
+}
+
+func (zm *ZMachine) StoreWord(address uint32, v uint16) {
+	zm.StoreByte(address, uint8(v>>8))
+	zm.StoreByte(address+1, uint8(v&0xFF))
+}
+
+// Unchecked accesses of the interpreter: instructions and strings,
+// wherever they are, and its own header fields.
+
+// We can only write to dynamic memory
+func (zm *ZMachine) IsSafeToWrite(address uint32) bool {
+	return address < zm.header.staticMemAddress
+}
+
+func (zm *ZMachine) GetUint8(offset uint32) uint8 {
+	if offset < uint32(len(zm.dynMem)) {
+		return zm.dynMem[offset]
+	}
+	if offset < uint32(len(zm.buf)) {
+		return zm.buf[offset]
+	}
+	panic(zm.outsideMemory(offset))
+}
+
+// Out of line, so that GetUint8 stays cheap enough to inline. The
+// interpreter can't carry on without the instruction or string it was
+// reading, whatever the policy.
+//
+//go:noinline
+func (zm *ZMachine) outsideMemory(address uint32) *MemoryError {
+	return zm.memoryError(address, false)
+}
+
+func (zm *ZMachine) checkAddress(address uint32) {
+	if address >= uint32(len(zm.buf)) {
+		panic(zm.outsideMemory(address))
+	}
+}
+
+func (zm *ZMachine) SetUint8(offset uint32, v uint8) {
+	if !zm.IsSafeToWrite(offset) {
+		panic(zm.memoryError(offset, true))
+	}
+	zm.dynMem[offset] = v
+}
+
+func (zm *ZMachine) GetUint16(offset uint32) uint16 {
+	return (uint16(zm.GetUint8(offset)) << 8) | (uint16)(zm.GetUint8(offset+1))
+}
+
+func (zm *ZMachine) SetUint16(offset uint32, v uint16) {
+	zm.SetUint8(offset, uint8(v>>8))
+	zm.SetUint8(offset+1, uint8(v&0xFF))
+}
+
+func (zm *ZMachine) GetUint32(offset uint32) uint32 {
+	return (uint32(zm.GetUint16(offset)) << 16) | uint32(zm.GetUint16(offset+2))
+}
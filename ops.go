@@ -1,18 +1,14 @@
 package zmachine
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
-	"math/rand"
-	"os"
 	"strings"
-	"time"
 )
 
 func ZCall(zm *ZMachine, args []uint16, numArgs uint16) {
 	if numArgs == 0 {
-		panic("Data corruption, call instruction requires at least 1 argument")
+		panic(zm.fault("Call without a routine address"))
 	}
 
 	// Save return address
@@ -35,6 +31,10 @@ func ZCall(zm *ZMachine, args []uint16, numArgs uint16) {
 
 	// Local function variables on the stack
 	numLocals := zm.ReadByte()
+	if numLocals > 15 {
+		zm.violation(zm.fault("Routine at 0x%X with %d local variables", functionAddress, numLocals))
+		numLocals = 15
+	}
 
 	// "When a routine is called, its local variables are created with initial values taken from the routine header.
 	// Next, the arguments are written into the local variables (argument 1 into local 1 and so on)."
@@ -48,27 +48,20 @@ func ZCall(zm *ZMachine, args []uint16, numArgs uint16) {
 		}
 		zm.stack.Push(localVar)
 	}
+	zm.stack.numLocals = int(numLocals)
 }
 
 //  storew array word-index value
 func ZStoreW(zm *ZMachine, args []uint16, numArgs uint16) {
 
 	address := uint32(args[0] + args[1]*2)
-	if !zm.IsSafeToWrite(address) {
-		panic("Access violation")
-	}
-
-	zm.SetUint16(address, args[2])
+	zm.StoreWord(address, args[2])
 }
 
 func ZStoreB(zm *ZMachine, args []uint16, numArgs uint16) {
 
 	address := uint32(args[0] + args[1])
-	if !zm.IsSafeToWrite(address) {
-		panic("Access violation")
-	}
-
-	zm.buf[address] = uint8(args[2])
+	zm.StoreByte(address, uint8(args[2]))
 }
 
 func ZPutProp(zm *ZMachine, args []uint16, numArgs uint16) {
@@ -78,27 +71,54 @@ func ZPutProp(zm *ZMachine, args []uint16, numArgs uint16) {
 func ZRead(zm *ZMachine, args []uint16, numArgs uint16) {
 
 	textAddress := args[0]
-	maxChars := uint16(zm.buf[textAddress])
+	maxChars := uint16(zm.LoadByte(uint32(textAddress)))
 	if maxChars == 0 {
-		panic("Invalid max chars")
+		zm.violation(zm.fault("Text buffer at 0x%X has no room for input", textAddress))
+		maxChars = 1
 	}
 	maxChars--
 
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
+	zm.showStatus()
+	if zm.stepping {
+		// SendLine finishes the instruction
+		zm.waiting = InputRequest{
+			Kind:        INPUT_LINE,
+			MaxLength:   int(maxChars),
+			TextBuffer:  uint32(textAddress),
+			ParseBuffer: uint32(args[1]),
+		}
+		return
+	}
+
+	input, err := zm.readLine()
+	if err != nil {
+		// Out of input, nothing more the game can do
+		zm.Done = true
+		return
+	}
+	zm.storeLine(textAddress, args[1], input)
+}
+
+// Stores a line of input in the text buffer and its words in the parse buffer
+func (zm *ZMachine) storeLine(textAddress uint16, parseBuffer uint16, input string) {
+	maxChars := uint16(zm.LoadByte(uint32(textAddress))) - 1
 
 	input = strings.ToLower(input)
-	input = strings.Trim(input, "\r\n")
 
-	copy(zm.buf[textAddress+1:textAddress+maxChars], input)
-	zm.buf[textAddress+uint16(len(input))+1] = 0
+	if len(input) > int(maxChars) {
+		input = input[:maxChars]
+	}
+	for i := 0; i < len(input); i++ {
+		zm.StoreByte(uint32(textAddress)+1+uint32(i), input[i])
+	}
+	zm.StoreByte(uint32(textAddress)+uint32(len(input))+1, 0)
 
 	var words []string
 	var wordStarts []uint16
 	var stringBuffer bytes.Buffer
-	prevWordStart := uint16(1)
-	for i := uint16(1); zm.buf[textAddress+i] != 0; i++ {
-		ch := zm.buf[textAddress+i]
+	prevWordStart := uint16(0xFFFF)
+	for i := uint16(1); zm.LoadByte(uint32(textAddress+i)) != 0; i++ {
+		ch := zm.LoadByte(uint32(textAddress + i))
 		if ch == ' ' {
 			if prevWordStart < 0xFFFF {
 				words = append(words, stringBuffer.String())
@@ -121,15 +141,15 @@ func ZRead(zm *ZMachine, args []uint16, numArgs uint16) {
 
 	// TODO: include other separators, not only spaces
 
-	parseAddress := uint32(args[1])
-	maxTokens := zm.buf[parseAddress]
+	parseAddress := uint32(parseBuffer)
+	maxTokens := zm.LoadByte(parseAddress)
 	//DebugPrintf("Max tokens: %d\n", maxTokens)
 	parseAddress++
 	numTokens := uint8(len(words))
 	if numTokens > maxTokens {
 		numTokens = maxTokens
 	}
-	zm.buf[parseAddress] = numTokens
+	zm.StoreByte(parseAddress, numTokens)
 	parseAddress++
 
 	// "Each block consists of the byte address of the word in the dictionary, if it is in the dictionary, or 0 if it isn't;
@@ -145,24 +165,25 @@ func ZRead(zm *ZMachine, args []uint16, numArgs uint16) {
 		dictionaryAddress := zm.FindInDictionary(w)
 		DebugPrintf("Dictionary address: 0x%X\n", dictionaryAddress)
 
-		zm.SetUint16(parseAddress, dictionaryAddress)
-		zm.buf[parseAddress+2] = uint8(len(w))
-		zm.buf[parseAddress+3] = uint8(wordStarts[i])
+		zm.StoreWord(parseAddress, dictionaryAddress)
+		zm.StoreByte(parseAddress+2, uint8(len(w)))
+		zm.StoreByte(parseAddress+3, uint8(wordStarts[i]))
 		parseAddress += 4
 	}
 }
 
 func ZPrintChar(zm *ZMachine, args []uint16, numArgs uint16) {
 	ch := args[0]
-	PrintZChar(ch)
+	zm.PrintZChar(ch)
 }
 
 func ZPrintNum(zm *ZMachine, args []uint16, numArgs uint16) {
-	fmt.Printf("%d", int16(args[0]))
+	zm.print(fmt.Sprintf("%d", int16(args[0])))
 }
 
 // If range is positive, returns a uniformly random number between 1 and range.
 // If range is negative, the random number generator is seeded to that value and the return value is 0.
+// Seeds below 1000 switch to predictable mode (1, 2, ..., seed), see ZRandomGenerator.
 // Most interpreters consider giving 0 as range illegal (because they attempt a division with remainder by the range),
 /// but correct behaviour is to reseed the generator in as random a way as the interpreter can (e.g. by using the time
 // in milliseconds).
@@ -170,13 +191,10 @@ func ZRandom(zm *ZMachine, args []uint16, numArgs uint16) {
 	randRange := int16(args[0])
 
 	if randRange > 0 {
-		r := rand.Int31n(int32(randRange)) // [0, n]
-		zm.StoreResult(uint16(r + 1))
-	} else if randRange < 0 {
-		rand.Seed(int64(randRange * -1))
-		zm.StoreResult(0)
+		r := zm.rng.Next(uint16(randRange)) // [1, n]
+		zm.StoreResult(r)
 	} else {
-		rand.Seed(time.Now().Unix())
+		zm.rng.Reseed(-int64(randRange))
 		zm.StoreResult(0)
 	}
 }
@@ -186,19 +204,62 @@ func ZPush(zm *ZMachine, args []uint16, numArgs uint16) {
 }
 
 func ZPull(zm *ZMachine, args []uint16, numArgs uint16) {
-	r := zm.stack.Pop()
+	r := zm.popStack()
 	DebugPrintf("Popped %d 0x%X %d %d\n", r, zm.ip, numArgs, args[0])
 	zm.StoreAtLocation(args[0], r)
 }
 
-func ZNOP_VAR(zm *ZMachine, args []uint16, numArgs uint16) {
-	fmt.Printf("IP=0x%X\n", zm.ip)
-	panic("NOP VAR")
+func ZSplitWindow(zm *ZMachine, args []uint16, numArgs uint16) {
+	if s := zm.screen(); s != nil {
+		s.SplitWindow(int(args[0]))
+	}
+}
+
+func ZSetWindow(zm *ZMachine, args []uint16, numArgs uint16) {
+	if s := zm.screen(); s != nil {
+		s.SetWindow(int(args[0]))
+	}
+}
+
+// output_stream number table: negative numbers deselect. The transcript
+// is only the Flags 2 bit, front ends keep the transcripts, and player
+// commands aren't recorded.
+func ZOutputStream(zm *ZMachine, args []uint16, numArgs uint16) {
+	switch stream := int16(args[0]); stream {
+	case STREAM_SCREEN:
+		zm.screenOff = false
+	case -STREAM_SCREEN:
+		zm.screenOff = true
+	case STREAM_TRANSCRIPT:
+		zm.setTranscript(true)
+	case -STREAM_TRANSCRIPT:
+		zm.setTranscript(false)
+	case STREAM_MEMORY:
+		if numArgs < 2 {
+			zm.violation(zm.fault("Output stream 3 selected without a table"))
+			return
+		}
+		zm.selectMemoryStream(uint32(args[1]))
+	case -STREAM_MEMORY:
+		zm.deselectMemoryStream()
+	case 0, STREAM_COMMANDS, -STREAM_COMMANDS:
+	default:
+		zm.violation(zm.fault("Unknown output stream %d", stream))
+	}
 }
 
-func ZNOP(zm *ZMachine, args []uint16) {
-	fmt.Printf("IP=0x%X\n", zm.ip)
-	panic("NOP 2OP")
+// input_stream number: input always comes from the input reader
+func ZInputStream(zm *ZMachine, args []uint16, numArgs uint16) {
+}
+
+// sound_effect number effect volume: there is no sound
+func ZSoundEffect(zm *ZMachine, args []uint16, numArgs uint16) {
+}
+
+// Opcode numbers not defined in version 3. Unless strict, they do
+// nothing.
+func ZIllegal(zm *ZMachine, args []uint16, numArgs uint16) {
+	zm.illegalOpcode()
 }
 
 func GenericBranch(zm *ZMachine, conditionSatisfied bool) {
@@ -289,7 +350,7 @@ func ZMul(zm *ZMachine, args []uint16, numArgs uint16) {
 
 func ZDiv(zm *ZMachine, args []uint16, numArgs uint16) {
 	if args[1] == 0 {
-		panic("Division by zero")
+		panic(zm.fault("Division by zero"))
 	}
 
 	r := int16(args[0]) / int16(args[1])
@@ -298,7 +359,7 @@ func ZDiv(zm *ZMachine, args []uint16, numArgs uint16) {
 
 func ZMod(zm *ZMachine, args []uint16, numArgs uint16) {
 	if args[1] == 0 {
-		panic("Division by zero (mod)")
+		panic(zm.fault("Division by zero (mod)"))
 	}
 
 	r := int16(args[0]) % int16(args[1])
@@ -333,7 +394,7 @@ func ZClearAttr(zm *ZMachine, args []uint16, numArgs uint16) {
 func ZLoadB(zm *ZMachine, args []uint16, numArgs uint16) {
 
 	address := args[0] + args[1]
-	value := zm.buf[address]
+	value := zm.LoadByte(uint32(address))
 
 	zm.StoreResult(uint16(value))
 }
@@ -356,7 +417,7 @@ func ZGetNextProp(zm *ZMachine, args []uint16, numArgs uint16) {
 // array word-index -> (result)
 func ZLoadW(zm *ZMachine, args []uint16, numArgs uint16) {
 	address := uint32(args[0] + (args[1] * 2))
-	value := GetUint16(zm.buf, address)
+	value := zm.LoadWord(address)
 
 	zm.StoreResult(value)
 }
@@ -424,7 +485,7 @@ func ZGetPropLen(zm *ZMachine, arg uint16) {
 	} else {
 		// Arg = direct address of the property block
 		// To get size, we need to go 1 byte back
-		propSize := zm.buf[arg-1]
+		propSize := zm.LoadByte(uint32(arg - 1))
 		numBytes := (propSize >> 5) + 1
 		zm.StoreResult(uint16(numBytes))
 	}
@@ -439,6 +500,11 @@ func ZLoad(zm *ZMachine, arg uint16) {
 	zm.StoreResult(arg)
 }
 
+// not value -> (result)
+func ZNot(zm *ZMachine, arg uint16) {
+	zm.StoreResult(^arg)
+}
+
 func ZInc(zm *ZMachine, arg uint16) {
 	zm.AddToVar(arg, 1)
 }
@@ -475,9 +541,8 @@ func ZJump(zm *ZMachine, arg uint16) {
 	zm.ip = uint32(jumpAddress)
 }
 
-func ZNOP1(zm *ZMachine, arg uint16) {
-	fmt.Printf("IP=0x%X\n", zm.ip)
-	panic("NOP1")
+func ZIllegal1(zm *ZMachine, arg uint16) {
+	zm.illegalOpcode()
 }
 
 func ZReturnTrue(zm *ZMachine) {
@@ -494,17 +559,17 @@ func ZPrint(zm *ZMachine) {
 
 func ZPrintRet(zm *ZMachine) {
 	zm.ip = zm.DecodeZString(zm.ip)
-	fmt.Printf("\n")
+	zm.print("\n")
 	ZRet(zm, 1)
 }
 
 func ZRetPopped(zm *ZMachine) {
-	retValue := zm.stack.Pop()
+	retValue := zm.popStack()
 	ZRet(zm, retValue)
 }
 
 func ZPop(zm *ZMachine) {
-	zm.stack.Pop()
+	zm.popStack()
 }
 
 func ZQuit(zm *ZMachine) {
@@ -512,9 +577,51 @@ func ZQuit(zm *ZMachine) {
 }
 
 func ZNewLine(zm *ZMachine) {
-	fmt.Printf("\n")
+	zm.print("\n")
+}
+
+// save ?(label)
+// The saved IP points at the branch data, so a later restore
+// continues by branching as if this save had succeeded.
+func ZSave(zm *ZMachine) {
+	if zm.Storage == nil {
+		GenericBranch(zm, false)
+		return
+	}
+	err := zm.Storage.Save(zm.SaveState())
+	GenericBranch(zm, err == nil)
+}
+
+// restore ?(label)
+// Only branches (false) on failure, on success execution continues at the save instruction's branch.
+func ZRestore(zm *ZMachine) {
+	if zm.Storage == nil {
+		GenericBranch(zm, false)
+		return
+	}
+	state, err := zm.Storage.Restore()
+	if err == nil {
+		err = zm.RestoreState(state)
+	}
+	GenericBranch(zm, err == nil)
+}
+
+func ZRestart(zm *ZMachine) {
+	zm.Restart()
+}
+
+func ZShowStatus(zm *ZMachine) {
+	zm.showStatus()
+}
+
+// verify ?(label)
+func ZVerify(zm *ZMachine) {
+	GenericBranch(zm, zm.Verify())
+}
+
+func ZNop(zm *ZMachine) {
 }
 
-func ZNOP0(zm *ZMachine) {
-	panic("NOP0")
+func ZIllegal0(zm *ZMachine) {
+	zm.illegalOpcode()
 }
@@ -0,0 +1,121 @@
+package zmachine
+
+import (
+	"fmt"
+	"os"
+)
+
+// What the machine does when a game breaks the rules of the standard:
+// uses object 0 or an attribute or property that can't exist, pops an
+// empty stack, reads or writes memory its region doesn't allow. Real
+// games do, harmlessly, so like the -Z levels of other interpreters the
+// machine can carry on: reads give 0, writes are dropped and missing
+// objects have no parent, children, attributes or properties.
+type ErrorPolicy uint8
+
+const (
+	// Stop with an error
+	POLICY_STRICT ErrorPolicy = iota
+	// Carry on, logging the first violation of each instruction
+	POLICY_WARN
+	// Carry on silently
+	POLICY_IGNORE
+)
+
+var policyNames = []string{"strict", "warn", "ignore"}
+
+func (p ErrorPolicy) String() string {
+	if int(p) < len(policyNames) {
+		return policyNames[p]
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", uint8(p))
+}
+
+// ParsePolicy returns the policy named "strict", "warn" or "ignore".
+func ParsePolicy(name string) (ErrorPolicy, error) {
+	for p, s := range policyNames {
+		if s == name {
+			return ErrorPolicy(p), nil
+		}
+	}
+	return POLICY_STRICT, fmt.Errorf("unknown error policy %q (strict, warn or ignore)", name)
+}
+
+// A fault of the running instruction
+func (zm *ZMachine) fault(format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{IP: zm.instructionStart, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Panics with fault when strict, returns for the caller to carry on
+// otherwise
+func (zm *ZMachine) violation(fault error) {
+	switch zm.Policy {
+	case POLICY_STRICT:
+		panic(fault)
+	case POLICY_WARN:
+		if zm.warned[zm.instructionStart] {
+			return
+		}
+		if zm.warned == nil {
+			zm.warned = make(map[uint32]bool)
+		}
+		zm.warned[zm.instructionStart] = true
+		w := zm.Warnings
+		if w == nil {
+			w = os.Stderr
+		}
+		fmt.Fprintln(w, fault)
+	}
+}
+
+// Whether the game may use object, reporting the violation if not
+func (zm *ZMachine) checkObject(object uint16) bool {
+	if object == NULL_OBJECT_INDEX || object > MAX_OBJECT {
+		zm.violation(zm.fault("Invalid object %d", object))
+		return false
+	}
+	return true
+}
+
+func (zm *ZMachine) checkAttribute(object uint16, attribute uint16) bool {
+	if !zm.checkObject(object) {
+		return false
+	}
+	if attribute > 31 {
+		zm.violation(zm.fault("Invalid attribute %d", attribute))
+		return false
+	}
+	return true
+}
+
+func (zm *ZMachine) illegalOpcode() {
+	zm.violation(zm.fault("Illegal opcode 0x%02X", zm.GetUint8(zm.instructionStart)))
+}
+
+// Pops the routine's evaluation stack; popping it empty gives 0 unless
+// strict
+func (zm *ZMachine) popStack() uint16 {
+	if zm.stack.IsEmpty() {
+		zm.violation(zm.fault("Stack underflow"))
+		return 0
+	}
+	return zm.stack.Pop()
+}
+
+// Local variable index (0 based) of the running routine. Using one it
+// doesn't declare reads 0 and drops writes unless strict.
+func (zm *ZMachine) readLocal(index int) uint16 {
+	if index >= zm.stack.numLocals {
+		zm.violation(zm.fault("Routine has no local variable %d", index+1))
+		return 0
+	}
+	return zm.stack.GetLocalVar(index)
+}
+
+func (zm *ZMachine) writeLocal(index int, v uint16) {
+	if index >= zm.stack.numLocals {
+		zm.violation(zm.fault("Routine has no local variable %d", index+1))
+		return
+	}
+	zm.stack.SetLocalVar(index, v)
+}
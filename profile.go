@@ -0,0 +1,188 @@
+package zmachine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Address the code outside of any routine is counted under
+const PROFILE_MAIN = 0
+
+// What a routine cost while profiled. Instructions and time are the
+// routine's own, without the routines it called.
+type RoutineProfile struct {
+	Address      uint32
+	Calls        uint64
+	Instructions uint64
+	Time         time.Duration
+}
+
+// Profiler counts the instructions run, by opcode and by routine, and
+// the time spent in each routine. Set ZMachine.Profiler to use it; the
+// machine then runs every instruction through the plain interpreter.
+type Profiler struct {
+	Instructions uint64
+	// By instruction kind (INSTRUCTION_0OP...) and opcode number
+	Opcodes  [4][32]uint64
+	Routines map[uint32]*RoutineProfile
+
+	// Routines being run, innermost last
+	frames []profileFrame
+	// Time of the last call or return
+	mark time.Time
+}
+
+type profileFrame struct {
+	localFrame int
+	routine    *RoutineProfile
+}
+
+func NewProfiler() *Profiler {
+	p := &Profiler{}
+	p.Reset()
+	return p
+}
+
+func (p *Profiler) Reset() {
+	p.Instructions = 0
+	p.Opcodes = [4][32]uint64{}
+	p.Routines = make(map[uint32]*RoutineProfile)
+	p.frames = nil
+}
+
+func (p *Profiler) routine(address uint32) *RoutineProfile {
+	r, ok := p.Routines[address]
+	if !ok {
+		r = &RoutineProfile{Address: address}
+		p.Routines[address] = r
+	}
+	return r
+}
+
+// Charges the time since the last mark to the running routine
+func (p *Profiler) charge(now time.Time) {
+	if len(p.frames) > 0 {
+		p.frames[len(p.frames)-1].routine.Time += now.Sub(p.mark)
+	}
+	p.mark = now
+}
+
+// Runs one instruction, following calls and returns through the frame
+// pointer of the stack
+func (zm *ZMachine) profileInstruction() {
+	p := zm.Profiler
+	if len(p.frames) == 0 {
+		p.mark = time.Now()
+		p.frames = append(p.frames, profileFrame{MAX_STACK, p.routine(PROFILE_MAIN)})
+		p.frames[0].routine.Calls++
+	}
+
+	// Only static and high memory go in the cache
+	var d *decodedInstruction
+	if zm.ip >= zm.header.staticMemAddress {
+		d = zm.cachedInstruction(zm.ip)
+	} else {
+		d = zm.decodeInstruction(zm.ip)
+	}
+	kind, instruction := d.kind, d.instruction
+	p.Instructions++
+	p.Opcodes[kind][instruction]++
+	p.frames[len(p.frames)-1].routine.Instructions++
+
+	zm.interpretInstruction()
+
+	localFrame := zm.stack.localFrame
+	top := p.frames[len(p.frames)-1].localFrame
+	if localFrame == top {
+		return
+	}
+	p.charge(time.Now())
+	if localFrame < top {
+		// Called a routine, or restored a game in a deeper one
+		address := uint32(PROFILE_MAIN)
+		if kind == INSTRUCTION_VAR && instruction == 0 {
+			address = PackedAddress(uint32(zm.operands[0]))
+		}
+		r := p.routine(address)
+		r.Calls++
+		p.frames = append(p.frames, profileFrame{localFrame, r})
+		return
+	}
+	for len(p.frames) > 1 && p.frames[len(p.frames)-1].localFrame < localFrame {
+		p.frames = p.frames[:len(p.frames)-1]
+	}
+}
+
+// Opcode name of an instruction kind and number
+func opcodeName(kind, instruction uint8) string {
+	names := [][]string{ZFunctionNames_0P, ZFunctionNames_1OP, ZFunctionNames_2OP, ZFunctionNames_VAR}[kind]
+	prefix := []string{"0OP", "1OP", "2OP", "VAR"}[kind]
+	if int(instruction) < len(names) {
+		return names[instruction]
+	}
+	return fmt.Sprintf("%s:%d", prefix, instruction)
+}
+
+// OpcodeCounts returns how many times each opcode ran, by name.
+func (p *Profiler) OpcodeCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for kind := range p.Opcodes {
+		for instruction, n := range p.Opcodes[kind] {
+			if n > 0 {
+				counts[opcodeName(uint8(kind), uint8(instruction))] += n
+			}
+		}
+	}
+	return counts
+}
+
+// Report writes the top routines by time and the opcode histogram.
+// top = 0 lists all routines.
+func (p *Profiler) Report(w io.Writer, top int) {
+	p.charge(time.Now())
+
+	routines := make([]*RoutineProfile, 0, len(p.Routines))
+	for _, r := range p.Routines {
+		routines = append(routines, r)
+	}
+	sort.Slice(routines, func(i, j int) bool {
+		if routines[i].Time != routines[j].Time {
+			return routines[i].Time > routines[j].Time
+		}
+		return routines[i].Address < routines[j].Address
+	})
+	if top > 0 && len(routines) > top {
+		routines = routines[:top]
+	}
+
+	fmt.Fprintf(w, "%d instructions\n\n", p.Instructions)
+	fmt.Fprintf(w, "%-10s %10s %12s %12s\n", "routine", "calls", "instructions", "time")
+	for _, r := range routines {
+		name := fmt.Sprintf("0x%05X", r.Address)
+		if r.Address == PROFILE_MAIN {
+			name = "main"
+		}
+		fmt.Fprintf(w, "%-10s %10d %12d %12v\n", name, r.Calls, r.Instructions, r.Time)
+	}
+
+	type opcodeCount struct {
+		name  string
+		count uint64
+	}
+	var opcodes []opcodeCount
+	for name, n := range p.OpcodeCounts() {
+		opcodes = append(opcodes, opcodeCount{name, n})
+	}
+	sort.Slice(opcodes, func(i, j int) bool {
+		if opcodes[i].count != opcodes[j].count {
+			return opcodes[i].count > opcodes[j].count
+		}
+		return opcodes[i].name < opcodes[j].name
+	})
+	fmt.Fprintf(w, "\n%-16s %12s\n", "opcode", "count")
+	for _, o := range opcodes {
+		fmt.Fprintf(w, "%-16s %12d\n", o.name, o.count)
+	}
+}
@@ -0,0 +1,85 @@
+package zmachine
+
+import "time"
+
+// Below this seed, random(-n) switches to predictable mode
+// and returns 1, 2, ..., n, 1, 2, ... as the standard suggests.
+const PREDICTABLE_SEED_LIMIT = 1000
+
+// Per-machine random number generator (xorshift64*).
+// It's a plain value so copying a ZMachine copies its random state too.
+type ZRandomGenerator struct {
+	state uint64
+	// Seed given to SeedRandom, used again when the game asks to reseed
+	fixedSeed int64
+	fixed     bool
+	// Predictable mode: counter cycles through 1..limit
+	counter uint16
+	limit   uint16
+}
+
+func (r *ZRandomGenerator) seed(seed int64) {
+	// splitmix64 so that small seeds still give a well mixed state
+	z := uint64(seed) + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	if z == 0 {
+		z = 1
+	}
+	r.state = z
+	r.limit = 0
+}
+
+func (r *ZRandomGenerator) next() uint64 {
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+	return r.state * 0x2545F4914F6CDD1D
+}
+
+// Returns a number in [1, n]
+func (r *ZRandomGenerator) Next(n uint16) uint16 {
+	if r.limit > 0 {
+		r.counter++
+		if r.counter > r.limit {
+			r.counter = 1
+		}
+		return uint16((uint32(r.counter)-1)%uint32(n)) + 1
+	}
+	return uint16(r.next()%uint64(n)) + 1
+}
+
+// Handles random with a negative or zero range
+func (r *ZRandomGenerator) Reseed(seed int64) {
+	if seed == 0 {
+		if r.fixed {
+			r.seed(r.fixedSeed)
+		} else {
+			r.seed(time.Now().UnixNano())
+		}
+	} else if seed < PREDICTABLE_SEED_LIMIT {
+		r.limit = uint16(seed)
+		r.counter = 0
+	} else {
+		r.seed(seed)
+	}
+}
+
+// SeedRandom makes the machine's random numbers reproducible.
+// The same seed is used again when the game asks for a random reseed.
+func (zm *ZMachine) SeedRandom(seed int64) {
+	zm.rng.fixedSeed = seed
+	zm.rng.fixed = true
+	zm.rng.seed(seed)
+}
+
+// SetPredictableRandom switches to predictable mode: random(n) returns
+// 1, 2, ..., limit, 1, 2, ... (each value taken modulo n).
+func (zm *ZMachine) SetPredictableRandom(limit uint16) {
+	if limit == 0 {
+		limit = 1
+	}
+	zm.rng.limit = limit
+	zm.rng.counter = 0
+}
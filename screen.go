@@ -0,0 +1,29 @@
+package zmachine
+
+const (
+	WINDOW_LOWER = 0
+	WINDOW_UPPER = 1
+)
+
+// An output also implementing Screen is told about the version 3 screen
+// model: the upper window and the status line. Plain writers just get
+// the text of every window.
+type Screen interface {
+	// Upper window height in lines, 0 = unsplit
+	SplitWindow(lines int)
+	SetWindow(window int)
+	ShowStatus(status StatusLine)
+}
+
+func (zm *ZMachine) screen() Screen {
+	s, _ := zm.output.(Screen)
+	return s
+}
+
+// Status line update in versions 1 to 3, done before every read
+// and by show_status
+func (zm *ZMachine) showStatus() {
+	if s := zm.screen(); s != nil && zm.header.Version <= 3 {
+		s.ShowStatus(zm.StatusLine())
+	}
+}
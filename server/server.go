@@ -0,0 +1,342 @@
+// Package server hosts Z-machine games over plain TCP (telnet).
+// Every connection plays its own machine on a shared story image.
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awgh/zmachine"
+)
+
+type Server struct {
+	// Sessions are dropped after this long without input, 0 = never
+	IdleTimeout time.Duration
+	// If set, players pick a name and their saved games are kept there,
+	// one file per name; a name can't be used by two sessions at once.
+	// Otherwise each session has a single in-memory save slot.
+	SaveDir string
+	// Maximum number of simultaneous sessions, 0 = no limit
+	MaxSessions int
+	// Header configuration for every machine, zmachine.DefaultConfig() by default
+	Config zmachine.Config
+	// A game going over these in a turn is stopped, zmachine.DefaultLimits() by default
+	Limits zmachine.Limits
+	// Logs connections and errors, nil = log package default
+	ErrorLog *log.Logger
+
+	story []byte
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	sessions  map[*session]bool
+	names     map[string]bool
+	nextID    int
+	closed    bool
+}
+
+var ErrServerClosed = errors.New("server: closed")
+
+// New checks the story for the sessions to play.
+func New(story []byte) (*Server, error) {
+	story, err := zmachine.StoryBytes(story)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		Config:    zmachine.DefaultConfig(),
+		Limits:    zmachine.DefaultLimits(),
+		story:     story,
+		listeners: make(map[net.Listener]bool),
+		sessions:  make(map[*session]bool),
+		names:     make(map[string]bool),
+	}, nil
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and plays a game on each one,
+// until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		sess, err := s.newSession(conn)
+		if err != nil {
+			fmt.Fprintf(conn, "%v\r\n", err)
+			conn.Close()
+			continue
+		}
+		go sess.run()
+	}
+}
+
+// Close stops the listeners and disconnects every session.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for sess := range s.sessions {
+		sess.cancel()
+		sess.conn.Close()
+	}
+	return nil
+}
+
+// Number of games being played
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *Server) newSession(conn net.Conn) (*session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrServerClosed
+	}
+	if s.MaxSessions > 0 && len(s.sessions) >= s.MaxSessions {
+		return nil, errors.New("Sorry, the server is full. Please try again later.")
+	}
+
+	zm, err := zmachine.LoadBytes(s.story)
+	if err != nil {
+		return nil, err
+	}
+	zm.Configure(s.Config)
+	zm.Limits = s.Limits
+
+	s.nextID++
+	out := bufio.NewWriter(conn)
+	sess := &session{
+		id:     s.nextID,
+		server: s,
+		conn:   conn,
+		in:     bufio.NewReader(&telnetReader{conn: conn, out: out, timeout: s.IdleTimeout}),
+		out:    out,
+		zm:     zm,
+	}
+	sess.ctx, sess.cancel = context.WithCancel(context.Background())
+	zm.SetOutput(&telnetWriter{out})
+	zm.Storage = &zmachine.MemoryStorage{}
+
+	s.sessions[sess] = true
+	return sess, nil
+}
+
+func (s *Server) endSession(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	if sess.name != "" {
+		delete(s.names, sess.name)
+	}
+	s.mu.Unlock()
+}
+
+// Gives the session the name for its saved games, false if another
+// session has it
+func (s *Server) claimName(sess *session, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names[name] {
+		return false
+	}
+	s.names[name] = true
+	sess.name = name
+	return true
+}
+
+type session struct {
+	id     int
+	server *Server
+	// Under SaveDir, once logged in
+	name   string
+	conn   net.Conn
+	in     *bufio.Reader
+	out    *bufio.Writer
+	zm     *zmachine.ZMachine
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (sess *session) run() {
+	s := sess.server
+	s.logf("session %d: connected from %v", sess.id, sess.conn.RemoteAddr())
+
+	defer func() {
+		sess.out.Flush()
+		sess.cancel()
+		sess.conn.Close()
+		s.endSession(sess)
+		s.logf("session %d: disconnected", sess.id)
+	}()
+
+	if s.SaveDir != "" {
+		storage, err := sess.login()
+		if err != nil {
+			sess.idle(err)
+			return
+		}
+		sess.zm.Storage = storage
+	}
+
+	for {
+		req, err := sess.zm.Run(sess.ctx)
+		if err == nil && !sess.zm.Done {
+			err = sess.input(req)
+		}
+		if crashed(err) {
+			s.logf("session %d: %v", sess.id, err)
+			sess.out.WriteString("\r\n[The game crashed.]\r\n")
+			return
+		}
+		if err == zmachine.ErrInstructionLimit || err == zmachine.ErrTimeLimit {
+			s.logf("session %d: %v", sess.id, err)
+			sess.out.WriteString("\r\n[The game was stopped.]\r\n")
+			return
+		}
+		if err != nil {
+			sess.idle(err)
+			return
+		}
+		if sess.zm.Done {
+			return
+		}
+	}
+}
+
+// Tells the player they're being disconnected if err is the idle timeout
+func (sess *session) idle(err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		sess.server.logf("session %d: idle for %v", sess.id, sess.server.IdleTimeout)
+		sess.out.WriteString("\r\n[Disconnected for being idle too long.]\r\n")
+	}
+}
+
+// The game failed: the interpreter's own checks, or the game breaking
+// the memory rules
+func crashed(err error) bool {
+	var runtimeErr *zmachine.RuntimeError
+	var memoryErr *zmachine.MemoryError
+	return errors.As(err, &runtimeErr) || errors.As(err, &memoryErr)
+}
+
+// Reads the player's answer to the game's input request
+func (sess *session) input(req zmachine.InputRequest) error {
+	if req.Kind == zmachine.INPUT_CHAR {
+		for {
+			r, _, err := sess.in.ReadRune()
+			if err != nil {
+				return err
+			}
+			switch {
+			case r == '\r':
+				continue
+			case r == '\n':
+				return sess.zm.SendKey(13)
+			case r >= 32 && r <= 126:
+				return sess.zm.SendKey(uint16(r))
+			}
+			return sess.zm.SendKey('?')
+		}
+	}
+
+	line, err := sess.in.ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	return sess.zm.SendLine(strings.TrimRight(line, "\r\n"))
+}
+
+// Asks for the name saved games are kept under
+func (sess *session) login() (zmachine.ZStorage, error) {
+	for {
+		sess.out.WriteString("Name for your saved games: ")
+		line, err := sess.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		name := saveName(line)
+		if name == "" {
+			sess.out.WriteString("Please use letters and digits only.\r\n")
+			continue
+		}
+		if !sess.server.claimName(sess, name) {
+			sess.out.WriteString("That name is already playing, please pick another.\r\n")
+			continue
+		}
+		return &zmachine.FileStorage{Path: filepath.Join(sess.server.SaveDir, name+".sav")}, nil
+	}
+}
+
+func saveName(line string) string {
+	name := strings.TrimSpace(line)
+	if name == "" || len(name) > 32 {
+		return ""
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return ""
+		}
+	}
+	return strings.ToLower(name)
+}
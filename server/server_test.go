@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+func newServer(t *testing.T) *Server {
+	story, err := walkthrough.ReadStory(filepath.Join("..", "bench", "stories", "mini.zas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ErrorLog = log.New(ioutil.Discard, "", 0)
+	return s
+}
+
+// Serves on a loopback port, returns its address
+func serve(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+type player struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *player {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &player{t, conn, bufio.NewReader(conn)}
+}
+
+// Output up to and including the prompt
+func (p *player) readUntil(prompt string) string {
+	var out strings.Builder
+	for !strings.HasSuffix(out.String(), prompt) {
+		c, err := p.in.ReadByte()
+		if err != nil {
+			p.t.Fatalf("%v waiting for %q after %q", err, prompt, out.String())
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// Output until the server hangs up
+func (p *player) readAll() string {
+	out, err := ioutil.ReadAll(p.in)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return string(out)
+}
+
+func (p *player) send(line string) {
+	if _, err := p.conn.Write([]byte(line + "\r\n")); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func waitSessions(t *testing.T, s *Server, n int) {
+	for deadline := time.Now().Add(5 * time.Second); s.Sessions() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sessions, want %d", s.Sessions(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	s := newServer(t)
+	p := dial(t, serve(t, s))
+
+	if out := p.readUntil(">"); !strings.Contains(out, "Mini test story\r\nWest of House\r\n") {
+		t.Errorf("opening %q", out)
+	}
+	p.send("take lamp")
+	if out := p.readUntil(">"); !strings.Contains(out, "Taken.") {
+		t.Errorf("take lamp: %q", out)
+	}
+	p.send("quit")
+	if out := p.readAll(); !strings.Contains(out, "Score: 5") {
+		t.Errorf("quit: %q", out)
+	}
+	waitSessions(t, s, 0)
+}
+
+func TestDisconnect(t *testing.T) {
+	s := newServer(t)
+	p := dial(t, serve(t, s))
+	p.readUntil(">")
+	if n := s.Sessions(); n != 1 {
+		t.Fatalf("%d sessions", n)
+	}
+	p.conn.Close()
+	waitSessions(t, s, 0)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := newServer(t)
+	s.IdleTimeout = 100 * time.Millisecond
+	p := dial(t, serve(t, s))
+	if out := p.readAll(); !strings.HasSuffix(out, "[Disconnected for being idle too long.]\r\n") {
+		t.Errorf("output %q", out)
+	}
+	waitSessions(t, s, 0)
+}
+
+func TestMaxSessions(t *testing.T) {
+	s := newServer(t)
+	s.MaxSessions = 1
+	addr := serve(t, s)
+	first := dial(t, addr)
+	first.readUntil(">")
+
+	if out := dial(t, addr).readAll(); !strings.Contains(out, "server is full") {
+		t.Errorf("second session got %q", out)
+	}
+	first.conn.Close()
+	waitSessions(t, s, 0)
+	dial(t, addr).readUntil(">")
+}
+
+// Saved games go in a file per name, which one session at a time has
+func TestSaveDir(t *testing.T) {
+	s := newServer(t)
+	s.SaveDir = t.TempDir()
+	addr := serve(t, s)
+
+	alice := dial(t, addr)
+	alice.readUntil("Name for your saved games: ")
+	alice.send("Alice")
+	alice.readUntil(">")
+	alice.send("save")
+	if out := alice.readUntil(">"); !strings.Contains(out, "Ok.") {
+		t.Errorf("save: %q", out)
+	}
+	if _, err := os.Stat(filepath.Join(s.SaveDir, "alice.sav")); err != nil {
+		t.Error(err)
+	}
+
+	other := dial(t, addr)
+	other.readUntil("Name for your saved games: ")
+	other.send("alice")
+	if out := other.readUntil("Name for your saved games: "); !strings.Contains(out, "already playing") {
+		t.Errorf("second alice: %q", out)
+	}
+	other.send("bob")
+	other.readUntil(">")
+
+	alice.conn.Close()
+	waitSessions(t, s, 1)
+	again := dial(t, addr)
+	again.readUntil("Name for your saved games: ")
+	again.send("alice")
+	again.readUntil(">")
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// Telnet protocol bytes
+const (
+	IAC  = 255
+	DONT = 254
+	DO   = 253
+	WONT = 252
+	WILL = 251
+	SB   = 250
+	SE   = 240
+)
+
+// Player input from a connection, with telnet negotiation stripped.
+// Flushes pending output before waiting, and gives up after the idle timeout.
+type telnetReader struct {
+	conn    net.Conn
+	out     *bufio.Writer
+	timeout time.Duration
+	buf     [512]byte
+	// Parser state carried between reads
+	state byte
+}
+
+const (
+	stateData = iota
+	stateIAC
+	stateOption
+	stateSubnegotiation
+	stateSubnegotiationIAC
+)
+
+func (r *telnetReader) Read(p []byte) (int, error) {
+	if err := r.out.Flush(); err != nil {
+		return 0, err
+	}
+	for {
+		if r.timeout > 0 {
+			r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+		}
+		max := len(p)
+		if max > len(r.buf) {
+			max = len(r.buf)
+		}
+		n, err := r.conn.Read(r.buf[:max])
+		out := 0
+		for _, c := range r.buf[:n] {
+			switch r.state {
+			case stateData:
+				if c == IAC {
+					r.state = stateIAC
+				} else if c != 0 {
+					p[out] = c
+					out++
+				}
+			case stateIAC:
+				switch c {
+				case IAC:
+					// Escaped 255, not a character a story can use
+					r.state = stateData
+				case WILL, WONT, DO, DONT:
+					r.state = stateOption
+				case SB:
+					r.state = stateSubnegotiation
+				default:
+					r.state = stateData
+				}
+			case stateOption:
+				r.state = stateData
+			case stateSubnegotiation:
+				if c == IAC {
+					r.state = stateSubnegotiationIAC
+				}
+			case stateSubnegotiationIAC:
+				if c == SE {
+					r.state = stateData
+				} else {
+					r.state = stateSubnegotiation
+				}
+			}
+		}
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// Game output to a connection, telnet wants CRLF line endings
+type telnetWriter struct {
+	out *bufio.Writer
+}
+
+func (w *telnetWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c == '\n' {
+			if _, err := w.out.WriteString("\r\n"); err != nil {
+				return 0, err
+			}
+		} else if err := w.out.WriteByte(c); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,166 @@
+package zmachine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ZStorage keeps saved games for the save and restore instructions.
+type ZStorage interface {
+	Save(state []byte) error
+	Restore() ([]byte, error)
+}
+
+var ErrNoSavedGame = errors.New("zmachine: no saved game")
+
+// MemoryStorage is a single save slot living as long as the value.
+type MemoryStorage struct {
+	state []byte
+}
+
+func (m *MemoryStorage) Save(state []byte) error {
+	m.state = append([]byte(nil), state...)
+	return nil
+}
+
+func (m *MemoryStorage) Restore() ([]byte, error) {
+	if m.state == nil {
+		return nil, ErrNoSavedGame
+	}
+	return m.state, nil
+}
+
+// Clone returns a slot holding the same saved game.
+func (m *MemoryStorage) Clone() *MemoryStorage {
+	return &MemoryStorage{state: m.state}
+}
+
+// FileStorage keeps the saved game in the file at Path.
+type FileStorage struct {
+	Path string
+}
+
+func (f *FileStorage) Save(state []byte) error {
+	return ioutil.WriteFile(f.Path, state, 0644)
+}
+
+func (f *FileStorage) Restore() ([]byte, error) {
+	return ioutil.ReadFile(f.Path)
+}
+
+// Version 2 frames also keep the number of locals
+const SAVE_MAGIC = "ZSV2"
+
+// Flags 2 bits the interpreter keeps across restart and restore
+// (transcripting and fixed pitch font)
+const FLAGS2_PRESERVED = 0x3
+
+type saveHeader struct {
+	Magic      [4]byte
+	Release    uint16
+	Serial     [6]byte
+	Checksum   uint16
+	IP         uint32
+	DynMemSize uint32
+	StackTop   uint32
+	LocalFrame uint32
+	NumLocals  uint32
+}
+
+// SaveState returns a snapshot of the game: dynamic memory, stack and IP.
+func (zm *ZMachine) SaveState() []byte {
+	var buf bytes.Buffer
+
+	header := saveHeader{
+		Release:    zm.header.release,
+		Serial:     zm.header.serial,
+		Checksum:   zm.header.checksum,
+		IP:         zm.ip,
+		DynMemSize: uint32(len(zm.dynMem)),
+		StackTop:   uint32(zm.stack.top),
+		LocalFrame: uint32(zm.stack.localFrame),
+		NumLocals:  uint32(zm.stack.numLocals),
+	}
+	copy(header.Magic[:], SAVE_MAGIC)
+
+	binary.Write(&buf, binary.BigEndian, header)
+	buf.Write(zm.dynMem)
+	binary.Write(&buf, binary.BigEndian, zm.stack.stack[zm.stack.top:])
+
+	return buf.Bytes()
+}
+
+// RestoreState restores a snapshot made by SaveState for the same story.
+func (zm *ZMachine) RestoreState(state []byte) error {
+	r := bytes.NewReader(state)
+
+	var header saveHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("invalid saved game: %v", err)
+	}
+	if string(header.Magic[:]) != SAVE_MAGIC {
+		return fmt.Errorf("invalid saved game")
+	}
+	if header.Release != zm.header.release || header.Serial != zm.header.serial ||
+		header.Checksum != zm.header.checksum {
+		return fmt.Errorf("saved game is from a different story")
+	}
+	if header.DynMemSize != uint32(len(zm.dynMem)) || header.StackTop > MAX_STACK ||
+		header.LocalFrame > MAX_STACK || header.NumLocals > 15 ||
+		header.LocalFrame < header.StackTop+header.NumLocals {
+		return fmt.Errorf("corrupted saved game")
+	}
+
+	dynMem := make([]uint8, header.DynMemSize)
+	stack := NewStack()
+	if _, err := io.ReadFull(r, dynMem); err != nil {
+		return fmt.Errorf("truncated saved game")
+	}
+	if err := binary.Read(r, binary.BigEndian, stack.stack[header.StackTop:]); err != nil {
+		return fmt.Errorf("truncated saved game")
+	}
+	stack.top = int(header.StackTop)
+	stack.localFrame = int(header.LocalFrame)
+	stack.numLocals = int(header.NumLocals)
+
+	flags2 := zm.dynMem[0x11] & FLAGS2_PRESERVED
+	zm.dynMem = dynMem
+	zm.dynMem[0x11] = (zm.dynMem[0x11] &^ FLAGS2_PRESERVED) | flags2
+	zm.stack = stack
+	zm.ip = header.IP
+	zm.writeConfig()
+
+	return nil
+}
+
+// Restart reloads dynamic memory from the story file and starts over.
+func (zm *ZMachine) Restart() {
+	flags2 := zm.dynMem[0x11] & FLAGS2_PRESERVED
+	copy(zm.dynMem, zm.buf)
+	zm.dynMem[0x11] = (zm.dynMem[0x11] &^ FLAGS2_PRESERVED) | flags2
+
+	zm.writeConfig()
+
+	zm.stack = NewStack()
+	zm.ip = uint32(zm.header.ip)
+	zm.memoryStreams = nil
+	zm.screenOff = false
+}
+
+// Verify checks the story file against the checksum in its header.
+func (zm *ZMachine) Verify() bool {
+	end := zm.header.fileLength
+	if end == 0 || end > uint32(len(zm.buf)) {
+		end = uint32(len(zm.buf))
+	}
+
+	sum := uint16(0)
+	for _, b := range zm.buf[HEADER_SIZE:end] {
+		sum += uint16(b)
+	}
+	return sum == zm.header.checksum
+}
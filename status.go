@@ -0,0 +1,61 @@
+package zmachine
+
+// What a version 3 status line shows
+type StatusLine struct {
+	Location string `json:"location"`
+	// Score and moves, or hours and minutes when TimeGame is set
+	Score    int16  `json:"score"`
+	Moves    uint16 `json:"moves"`
+	Hours    uint16 `json:"hours,omitempty"`
+	Minutes  uint16 `json:"minutes,omitempty"`
+	TimeGame bool   `json:"time_game,omitempty"`
+}
+
+// StatusLine reads the status line globals: the location object,
+// then score and moves (or hours and minutes in a "time game").
+func (zm *ZMachine) StatusLine() StatusLine {
+	status, _ := zm.profileStatus(DefaultProfile())
+	return status
+}
+
+// Status line from the globals of the profile, and the location object.
+// Version 3 games say in the header whether they are time games.
+func (zm *ZMachine) profileStatus(p GameProfile) (StatusLine, uint16) {
+	var status StatusLine
+
+	location := uint16(NULL_OBJECT_INDEX)
+	if p.LocationGlobal >= 0x10 {
+		location = zm.ReadGlobal(p.LocationGlobal)
+		if zm.validObject(location) {
+			status.Location = zm.GetObjectName(location)
+		} else {
+			location = NULL_OBJECT_INDEX
+		}
+	}
+
+	status.TimeGame = p.TimeGame
+	if zm.header.Version <= 3 {
+		// Flags 1 bit 1: status line type
+		status.TimeGame = (zm.GetUint8(0x1) & 0x2) != 0
+	}
+	var score, moves uint16
+	if p.ScoreGlobal >= 0x10 {
+		score = zm.ReadGlobal(p.ScoreGlobal)
+	}
+	if p.MovesGlobal >= 0x10 {
+		moves = zm.ReadGlobal(p.MovesGlobal)
+	}
+	if status.TimeGame {
+		status.Hours, status.Minutes = score, moves
+	} else {
+		status.Score, status.Moves = int16(score), moves
+	}
+	return status, location
+}
+
+// ReadStatus is StatusLine returning an error, instead of panicking,
+// when a broken game's globals point nowhere.
+func (zm *ZMachine) ReadStatus() (status StatusLine, err error) {
+	defer zm.recoverError(&err)
+	return zm.StatusLine(), nil
+}
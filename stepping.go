@@ -0,0 +1,184 @@
+package zmachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of input the machine can wait for. INPUT_CHAR is the key of
+// read_char, a version 4 opcode: version 3 games only read lines.
+const (
+	INPUT_NONE = iota
+	INPUT_LINE
+	INPUT_CHAR
+)
+
+// What a stopped machine is waiting for
+type InputRequest struct {
+	Kind int
+	// Line input only: longest line the game accepts and where it goes
+	MaxLength   int
+	TextBuffer  uint32
+	ParseBuffer uint32
+}
+
+var (
+	ErrNotWaitingForLine = errors.New("zmachine: not waiting for a line")
+	ErrNotWaitingForKey  = errors.New("zmachine: not waiting for a key")
+	ErrInstructionLimit  = errors.New("zmachine: instruction limit exceeded")
+	ErrTimeLimit         = errors.New("zmachine: time limit exceeded")
+)
+
+// How many instructions run between checks of the clock and the context
+const LIMITS_CHECK_INTERVAL = 1024
+
+// How much a turn (from one input to the next) may take, so hosted games
+// stuck in a loop can't hold a worker forever. Zero values mean no limit.
+type Limits struct {
+	Instructions int
+	Time         time.Duration
+}
+
+// Generous limits: real games take a few thousand instructions a turn
+func DefaultLimits() Limits {
+	return Limits{
+		Instructions: 10000000,
+		Time:         10 * time.Second,
+	}
+}
+
+// A panic of the interpreter, turned into an error by the stepping API
+type RuntimeError struct {
+	// Start of the instruction that failed
+	IP  uint32
+	Msg string
+
+	// What was recovered
+	cause interface{}
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("zmachine: %s (IP 0x%X)", e.Msg, e.IP)
+}
+
+func (zm *ZMachine) recoverError(err *error) {
+	if r := recover(); r != nil {
+		switch e := r.(type) {
+		case *MemoryError:
+			*err = e
+			return
+		case *RuntimeError:
+			if e.IP == 0 {
+				e.IP = zm.instructionStart
+			}
+			*err = e
+			return
+		}
+		*err = &RuntimeError{IP: zm.instructionStart, Msg: fmt.Sprint(r), cause: r}
+	}
+}
+
+// RunUntilInput runs the game until it asks for input or stops.
+// The returned request is INPUT_NONE once the game is over (Done),
+// otherwise it's answered with SendLine or SendKey before running again.
+//
+// From the first call on, input only comes from SendLine and SendKey,
+// never from the reader given to SetInput.
+func (zm *ZMachine) RunUntilInput() (InputRequest, error) {
+	return zm.Run(context.Background())
+}
+
+// Run is RunUntilInput stopping early when ctx is done or the turn goes
+// over Limits, with ctx.Err(), ErrInstructionLimit or ErrTimeLimit.
+// The machine is left in the middle of the turn; running it again
+// continues with a new budget.
+func (zm *ZMachine) Run(ctx context.Context) (req InputRequest, err error) {
+	defer zm.recoverError(&err)
+
+	zm.stepping = true
+	limits := zm.Limits
+	start := time.Now()
+	done := ctx.Done()
+
+	count := 0
+	for !zm.Done && zm.waiting.Kind == INPUT_NONE {
+		// Up to the next limits check
+		n := LIMITS_CHECK_INTERVAL - count%LIMITS_CHECK_INTERVAL
+		if limits.Instructions > 0 && limits.Instructions-count < n {
+			n = limits.Instructions - count
+		}
+		n = zm.execute(n)
+		count += n
+		zm.instructions += uint64(n)
+
+		if limits.Instructions > 0 && count >= limits.Instructions {
+			return zm.waiting, ErrInstructionLimit
+		}
+		if count%LIMITS_CHECK_INTERVAL == 0 {
+			if limits.Time > 0 && time.Since(start) > limits.Time {
+				return zm.waiting, ErrTimeLimit
+			}
+			select {
+			case <-done:
+				return zm.waiting, ctx.Err()
+			default:
+			}
+		}
+	}
+	return zm.waiting, nil
+}
+
+// Runs up to n instructions, fewer if the game ends or asks for input.
+// Returns how many ran.
+func (zm *ZMachine) execute(n int) int {
+	if zm.CompileRoutines && zm.Trace == nil && zm.Profiler == nil && !zm.DisableCache {
+		return zm.runCompiledSteps(n)
+	}
+	for i := 1; i <= n; i++ {
+		zm.InterpretInstruction()
+		if zm.Done || zm.waiting.Kind != INPUT_NONE {
+			return i
+		}
+	}
+	return n
+}
+
+// Instructions returns how many instructions Run has run so far.
+func (zm *ZMachine) Instructions() uint64 {
+	return zm.instructions
+}
+
+// The pending input request, INPUT_NONE while running
+func (zm *ZMachine) Waiting() InputRequest {
+	return zm.waiting
+}
+
+// SendLine answers a line input request. The line is cut to the
+// length the game accepts.
+func (zm *ZMachine) SendLine(line string) (err error) {
+	if zm.waiting.Kind != INPUT_LINE {
+		return ErrNotWaitingForLine
+	}
+	defer zm.recoverError(&err)
+
+	req := zm.waiting
+	zm.waiting = InputRequest{}
+	zm.storeLine(uint16(req.TextBuffer), uint16(req.ParseBuffer), line)
+	return nil
+}
+
+// SendKey answers a single key request with a ZSCII character
+// (13 for Enter). Only version 4 and later games ask for keys, so with
+// the version 3 stories the machine loads it returns ErrNotWaitingForKey.
+func (zm *ZMachine) SendKey(key uint16) (err error) {
+	if zm.waiting.Kind != INPUT_CHAR {
+		return ErrNotWaitingForKey
+	}
+	defer zm.recoverError(&err)
+
+	zm.waiting = InputRequest{}
+	zm.StoreResult(key)
+	return nil
+}
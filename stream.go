@@ -0,0 +1,62 @@
+package zmachine
+
+// Output streams of output_stream (7.1)
+const (
+	STREAM_SCREEN     = 1
+	STREAM_TRANSCRIPT = 2
+	STREAM_MEMORY     = 3
+	STREAM_COMMANDS   = 4
+)
+
+// Flags 2 bit 0: the game wants a transcript
+const FLAGS2_TRANSCRIPT = 0x1
+
+// Most tables stream 3 can have selected at once (7.1.2.1.1)
+const MAX_MEMORY_STREAMS = 16
+
+// Table selected by output_stream 3: a word with the number of
+// characters written, then the characters
+type memoryStream struct {
+	table  uint32
+	length uint16
+}
+
+// Writes the text in ZSCII after the characters already in the table
+func (m *memoryStream) write(zm *ZMachine, s string) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\n' {
+			c = 13
+		}
+		zm.StoreByte(m.table+2+uint32(m.length), c)
+		m.length++
+	}
+	zm.StoreWord(m.table, m.length)
+}
+
+func (zm *ZMachine) selectMemoryStream(table uint32) {
+	if len(zm.memoryStreams) == MAX_MEMORY_STREAMS {
+		zm.violation(zm.fault("Output stream 3 selected more than %d times", MAX_MEMORY_STREAMS))
+		return
+	}
+	zm.StoreWord(table, 0)
+	zm.memoryStreams = append(zm.memoryStreams, memoryStream{table: table})
+}
+
+// Back to the enclosing table, or the other streams
+func (zm *ZMachine) deselectMemoryStream() {
+	if n := len(zm.memoryStreams); n > 0 {
+		zm.memoryStreams = zm.memoryStreams[:n-1]
+	}
+}
+
+// Transcript bit, which the game reads to show whether scripting is on
+func (zm *ZMachine) setTranscript(on bool) {
+	flags2 := zm.LoadByte(0x11)
+	if on {
+		flags2 |= FLAGS2_TRANSCRIPT
+	} else {
+		flags2 &^= FLAGS2_TRANSCRIPT
+	}
+	zm.StoreByte(0x11, flags2)
+}
@@ -1,7 +1,5 @@
 package zmachine
 
-import "fmt"
-
 const (
 	OPERAND_LARGE    = 0x0
 	OPERAND_SMALL    = 0x1
@@ -37,11 +35,16 @@ type ZHeader struct {
 	globalVarAddress  uint32
 	staticMemAddress  uint32
 	abbreviationTable uint32
+	fileLength        uint32
+	release           uint16
+	serial            [6]byte
+	checksum          uint16
 }
 
 func (h *ZHeader) Read(buf []byte) {
 
 	h.Version = buf[0]
+	h.release = GetUint16(buf, 2)
 	h.hiMemBase = GetUint16(buf, 4)
 	h.ip = GetUint16(buf, 6)
 	h.dictAddress = uint32(GetUint16(buf, 0x8))
@@ -49,6 +52,10 @@ func (h *ZHeader) Read(buf []byte) {
 	h.globalVarAddress = uint32(GetUint16(buf, 0xC))
 	h.staticMemAddress = uint32(GetUint16(buf, 0xE))
 	h.abbreviationTable = uint32(GetUint16(buf, 0x18))
+	// "The length of the file, divided by 2" (versions 1-3)
+	h.fileLength = uint32(GetUint16(buf, 0x1A)) * 2
+	h.checksum = GetUint16(buf, 0x1C)
+	copy(h.serial[:], buf[0x12:0x18])
 
 	DebugPrintf("End of dyn mem: 0x%X\n", h.staticMemAddress)
 	DebugPrintf("Global vars: 0x%X\n", h.globalVarAddress)
@@ -65,10 +72,25 @@ var ZFunctions_VAR = []ZFunction{
 	ZRandom,
 	ZPush,
 	ZPull,
+	ZSplitWindow,
+	ZSetWindow,
+	ZIllegal, // call_vs2, version 4+
+	ZIllegal, // erase_window, version 4+
+	ZIllegal, // erase_line, version 4+
+	ZIllegal, // set_cursor, version 4+
+	ZIllegal, // get_cursor, version 4+
+	ZIllegal, // set_text_style, version 4+
+	ZIllegal, // buffer_mode, version 4+
+	ZOutputStream,
+	ZInputStream,
+	ZSoundEffect,
+	ZIllegal, // read_char, version 4+
+	// Versions 4+
+	ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal,
 }
 
 var ZFunctions_2OP = []ZFunction{
-	ZNOP_VAR,
+	ZIllegal,
 	ZJumpEqual,
 	ZJumpLess,
 	ZJumpGreater,
@@ -93,6 +115,8 @@ var ZFunctions_2OP = []ZFunction{
 	ZMul,
 	ZDiv,
 	ZMod,
+	// Versions 4+, and unused
+	ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal, ZIllegal,
 }
 
 var ZFunctions_1OP = []ZFunction1Op{
@@ -104,14 +128,14 @@ var ZFunctions_1OP = []ZFunction1Op{
 	ZInc,
 	ZDec,
 	ZPrintAddr,
-	ZNOP1,
+	ZIllegal1,
 	ZRemoveObj,
 	ZPrintObj,
 	ZRet,
 	ZJump,
 	ZPrintPAddr,
 	ZLoad,
-	ZNOP1,
+	ZNot,
 }
 
 var ZFunctions_0P = []ZFunction0Op{
@@ -119,14 +143,42 @@ var ZFunctions_0P = []ZFunction0Op{
 	ZReturnFalse,
 	ZPrint,
 	ZPrintRet,
-	ZNOP0,
-	ZNOP0,
-	ZNOP0,
-	ZNOP0,
+	ZNop,
+	ZSave,
+	ZRestore,
+	ZRestart,
 	ZRetPopped,
 	ZPop,
 	ZQuit,
 	ZNewLine,
+	ZShowStatus,
+	ZVerify,
+	ZIllegal0, // extended
+	ZIllegal0, // piracy
+}
+
+// Instruction names, used when tracing
+var ZFunctionNames_VAR = []string{
+	"call", "storew", "storeb", "put_prop", "sread", "print_char", "print_num", "random",
+	"push", "pull", "split_window", "set_window", "call_vs2", "erase_window", "erase_line", "set_cursor",
+	"get_cursor", "set_text_style", "buffer_mode", "output_stream", "input_stream", "sound_effect", "read_char",
+}
+
+var ZFunctionNames_2OP = []string{
+	"nop", "je", "jl", "jg", "dec_chk", "inc_chk", "jin", "test",
+	"or", "and", "test_attr", "set_attr", "clear_attr", "store", "insert_obj", "loadw",
+	"loadb", "get_prop", "get_prop_addr", "get_next_prop", "add", "sub", "mul", "div",
+	"mod",
+}
+
+var ZFunctionNames_1OP = []string{
+	"jz", "get_sibling", "get_child", "get_parent", "get_prop_len", "inc", "dec", "print_addr",
+	"call_1s", "remove_obj", "print_obj", "ret", "jump", "print_paddr", "load", "not",
+}
+
+var ZFunctionNames_0P = []string{
+	"rtrue", "rfalse", "print", "print_ret", "nop", "save", "restore", "restart",
+	"ret_popped", "pop", "quit", "new_line", "show_status", "verify",
 }
 
 type ZFunction func(*ZMachine, []uint16, uint16)
@@ -151,10 +203,11 @@ func GetUint32(buf []byte, offset uint32) uint32 {
 	return (uint32(buf[offset]) << 24) | (uint32(buf[offset+1]) << 16) | (uint32(buf[offset+2]) << 8) | uint32(buf[offset+3])
 }
 
-func PrintZChar(ch uint16) {
+func ZSCIIString(ch uint16) string {
 	if ch == 13 {
-		fmt.Printf("\n")
+		return "\n"
 	} else if ch >= 32 && ch <= 126 { // ASCII
-		fmt.Printf("%c", ch)
+		return string(rune(ch))
 	} // else ... do not bother
+	return ""
 }
@@ -0,0 +1,75 @@
+// Package walkthrough finds scripted playthroughs of test stories, as
+// used by packages bench and golden. A directory holds stories, story
+// files or zasm sources ending in .zas, each with a walkthrough of the
+// same name ending in .txt, one command per line.
+package walkthrough
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/awgh/zmachine/zasm"
+)
+
+// A story and its walkthrough
+type Walkthrough struct {
+	Name     string
+	Story    string
+	Commands string
+}
+
+// Find returns the stories of dir that have a walkthrough. A story file
+// comes before a source of the same name.
+func Find(dir string) ([]Walkthrough, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var walkthroughs []Walkthrough
+	for _, commands := range files {
+		base := strings.TrimSuffix(commands, ".txt")
+		matches, _ := filepath.Glob(base + ".z[1-8]")
+		if len(matches) == 0 {
+			matches, _ = filepath.Glob(base + ".zas")
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		walkthroughs = append(walkthroughs, Walkthrough{
+			Name:     filepath.Base(base),
+			Story:    matches[0],
+			Commands: commands,
+		})
+	}
+	return walkthroughs, nil
+}
+
+// ReadStory reads a story file, assembling it if it's a zasm source.
+func ReadStory(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) != ".zas" {
+		return data, nil
+	}
+	story, err := zasm.Assemble(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return story, nil
+}
+
+// ReadCommands reads a walkthrough, one command per line.
+func ReadCommands(path string) ([]string, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(strings.Replace(string(text), "\r\n", "\n", -1), "\n"), "\n"), nil
+}
@@ -0,0 +1,147 @@
+package zmachine
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Names the player object usually goes by
+var PLAYER_NAMES = []string{"yourself", "you", "cretin", "adventurer", "me", "myself", "self", "player"}
+
+// Where a game keeps its world state. Version 3 games must use the
+// status line globals; later games usually follow the same convention,
+// but not always.
+type GameProfile struct {
+	// Globals holding the location object, the score and the moves.
+	// 0 = the game has none.
+	LocationGlobal uint8
+	ScoreGlobal    uint8
+	MovesGlobal    uint8
+	// Score and moves globals hold hours and minutes instead.
+	// Version 3 games say so in the header.
+	TimeGame bool
+	// Player object, or the global holding it. Neither = the child of
+	// the location with one of PLAYER_NAMES.
+	Player       uint16
+	PlayerGlobal uint8
+	// Other globals holding world state, such as door and puzzle flags,
+	// which TryActions compares. Not the parser's scratch globals.
+	WorldGlobals []uint8
+}
+
+func DefaultProfile() GameProfile {
+	return GameProfile{
+		LocationGlobal: 0x10,
+		ScoreGlobal:    0x11,
+		MovesGlobal:    0x12,
+	}
+}
+
+// Known games by StoryID, used when ZMachine.Profile isn't set
+var (
+	profilesMu sync.RWMutex
+	profiles   = make(map[string]GameProfile)
+)
+
+// RegisterProfile makes p the profile of the story with StoryID id, for
+// machines without a Profile. Safe to call while games run.
+func RegisterProfile(id string, p GameProfile) {
+	profilesMu.Lock()
+	profiles[id] = p
+	profilesMu.Unlock()
+}
+
+// LookupProfile returns the profile registered for the StoryID id.
+func LookupProfile(id string) (GameProfile, bool) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	p, ok := profiles[id]
+	return p, ok
+}
+
+// StoryID identifies a story file by release and serial, e.g. "88-840726".
+func (zm *ZMachine) StoryID() string {
+	return fmt.Sprintf("%d-%s", zm.header.release, string(zm.header.serial[:]))
+}
+
+// ActiveProfile is the profile in use: ZMachine.Profile, or the known
+// game's, or DefaultProfile().
+func (zm *ZMachine) ActiveProfile() GameProfile {
+	if zm.Profile != nil {
+		return *zm.Profile
+	}
+	if p, ok := LookupProfile(zm.StoryID()); ok {
+		return p
+	}
+	return DefaultProfile()
+}
+
+type WorldState struct {
+	Location     uint16
+	LocationName string
+	Player       uint16
+	// Objects carried by the player, and their names
+	Inventory      []uint16
+	InventoryNames []string
+	// Score and moves, or hours and minutes when TimeGame is set
+	Score    int16
+	Moves    uint16
+	Hours    uint16
+	Minutes  uint16
+	TimeGame bool
+}
+
+func (zm *ZMachine) validObject(obj uint16) bool {
+	return obj != NULL_OBJECT_INDEX && obj <= zm.NumObjects()
+}
+
+// WorldState reports where the player is, what they carry and the
+// score, as the active profile says. Missing pieces are left zero.
+func (zm *ZMachine) WorldState() WorldState {
+	var ws WorldState
+	p := zm.ActiveProfile()
+
+	status, location := zm.profileStatus(p)
+	ws.Location, ws.LocationName = location, status.Location
+	ws.Score, ws.Moves = status.Score, status.Moves
+	ws.Hours, ws.Minutes = status.Hours, status.Minutes
+	ws.TimeGame = status.TimeGame
+
+	ws.Player = zm.playerObject(p, ws.Location)
+	if ws.Player != NULL_OBJECT_INDEX {
+		for obj := zm.GetFirstChild(ws.Player); obj != NULL_OBJECT_INDEX; obj = zm.GetSibling(obj) {
+			ws.Inventory = append(ws.Inventory, obj)
+			ws.InventoryNames = append(ws.InventoryNames, zm.GetObjectName(obj))
+			// A corrupted tree could loop
+			if len(ws.Inventory) > MAX_OBJECT {
+				break
+			}
+		}
+	}
+	return ws
+}
+
+func (zm *ZMachine) playerObject(p GameProfile, location uint16) uint16 {
+	if p.Player != NULL_OBJECT_INDEX {
+		return p.Player
+	}
+	if p.PlayerGlobal >= 0x10 {
+		if player := zm.ReadGlobal(p.PlayerGlobal); zm.validObject(player) {
+			return player
+		}
+		return NULL_OBJECT_INDEX
+	}
+	if location == NULL_OBJECT_INDEX {
+		return NULL_OBJECT_INDEX
+	}
+	for obj := zm.GetFirstChild(location); obj != NULL_OBJECT_INDEX; obj = zm.GetSibling(obj) {
+		name := strings.ToLower(zm.GetObjectName(obj))
+		for _, n := range PLAYER_NAMES {
+			if name == n {
+				return obj
+			}
+		}
+	}
+	return NULL_OBJECT_INDEX
+}
@@ -0,0 +1,88 @@
+package zmachine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+// Mini test story, waiting for its first command
+func miniMachine(t *testing.T) *ZMachine {
+	story, err := walkthrough.ReadStory(filepath.Join("bench", "stories", "mini.zas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zm, err := LoadBytes(story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zm.SetOutput(ioutil.Discard)
+	if _, err := zm.RunUntilInput(); err != nil {
+		t.Fatal(err)
+	}
+	return zm
+}
+
+func play(t *testing.T, zm *ZMachine, commands ...string) {
+	for _, command := range commands {
+		if err := zm.SendLine(command); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := zm.RunUntilInput(); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+}
+
+func TestWorldState(t *testing.T) {
+	zm := miniMachine(t)
+	play(t, zm, "take lamp", "north")
+
+	ws := zm.WorldState()
+	if ws.LocationName != "North of House" || zm.GetObjectName(ws.Player) != "yourself" {
+		t.Errorf("in %q as %q", ws.LocationName, zm.GetObjectName(ws.Player))
+	}
+	if !reflect.DeepEqual(ws.InventoryNames, []string{"brass lamp"}) {
+		t.Errorf("inventory %v", ws.InventoryNames)
+	}
+	if ws.Score != 5 || ws.Moves != 2 || ws.TimeGame {
+		t.Errorf("score %d, moves %d, time game %v", ws.Score, ws.Moves, ws.TimeGame)
+	}
+
+	status, err := zm.ReadStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Location != ws.LocationName || status.Score != ws.Score || status.Moves != ws.Moves {
+		t.Errorf("status %+v, world %+v", status, ws)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	zm := miniMachine(t)
+	play(t, zm, "take lamp")
+
+	// The game keeps its parser's verb where the moves usually are
+	RegisterProfile(zm.StoryID(), GameProfile{LocationGlobal: 0x10, ScoreGlobal: 0x11, MovesGlobal: 0x13, Player: 3})
+	defer func() {
+		profilesMu.Lock()
+		delete(profiles, zm.StoryID())
+		profilesMu.Unlock()
+	}()
+	if p, ok := LookupProfile(zm.StoryID()); !ok || p.Player != 3 {
+		t.Fatalf("registered profile %+v, %v", p, ok)
+	}
+	ws := zm.WorldState()
+	if ws.Moves != zm.FindInDictionary("take") || ws.Player != 3 || len(ws.Inventory) != 1 {
+		t.Errorf("world %+v", ws)
+	}
+
+	// Set on the machine, the profile wins
+	zm.Profile = &GameProfile{LocationGlobal: 0x10}
+	if ws := zm.WorldState(); ws.Score != 0 || ws.LocationName != "West of House" {
+		t.Errorf("world %+v", ws)
+	}
+}
@@ -0,0 +1,69 @@
+package wsterm
+
+import (
+	"encoding/json"
+)
+
+// Client plays a game hosted by a Handler, for tests and bots.
+type Client struct {
+	conn *Conn
+}
+
+// DialGame connects to a Handler, e.g. "ws://localhost:8080/play?story=zork1".
+func DialGame(url string) (*Client, error) {
+	conn, err := Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn}, nil
+}
+
+// Next returns the next event from the game.
+func (c *Client) Next() (*Event, error) {
+	msg, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	e := &Event{}
+	if err := json.Unmarshal(msg, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ReadTurn returns the events up to and including the next input
+// request, or the end of the game.
+func (c *Client) ReadTurn() ([]*Event, error) {
+	var events []*Event
+	for {
+		e, err := c.Next()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+		if e.Type == "input" || e.Type == "quit" {
+			return events, nil
+		}
+	}
+}
+
+func (c *Client) SendLine(line string) error {
+	return c.send(&Input{Type: "line", Line: line})
+}
+
+// SendKey sends a single character, "Enter" or "Backspace".
+func (c *Client) SendKey(key string) error {
+	return c.send(&Input{Type: "key", Key: key})
+}
+
+func (c *Client) send(in *Input) error {
+	msg, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(msg)
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
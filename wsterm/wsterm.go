@@ -0,0 +1,288 @@
+// Package wsterm streams Z-machine games to browser terminals over a
+// WebSocket. Connect to the handler with ?story=name (optional when only
+// one story is hosted) and optionally &width=80&height=24.
+//
+// Games are version 3 stories, the only ones the machine loads, so the
+// screen model is the status line and the upper window of split_window
+// and set_window: no text styles, cursor moves or erasing.
+//
+// The server sends JSON events:
+//
+//	{"type": "text", "text": "West of House\n"}
+//	{"type": "split", "value": 3}           upper window height in lines
+//	{"type": "window", "value": 1}          1 = upper window, 0 = lower
+//	{"type": "status", "status": {...}}     status line
+//	{"type": "input", "input": "line"}      the game waits for a line
+//	{"type": "backspace"}                   echo of a deleted key
+//	{"type": "quit"}                        the game is over
+//	{"type": "error", "text": "..."}
+//
+// A missing value means 0. The terminal answers with whole lines or single keys:
+//
+//	{"type": "line", "line": "open mailbox"}
+//	{"type": "key", "key": "o"}             or "Enter", "Backspace"
+//
+// While the game waits for a line, keys are edited into one and echoed
+// back as text events; whole lines are not echoed.
+package wsterm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/awgh/zmachine"
+)
+
+// Message from the server
+type Event struct {
+	Type   string               `json:"type"`
+	Text   string               `json:"text,omitempty"`
+	Value  int                  `json:"value,omitempty"`
+	Status *zmachine.StatusLine `json:"status,omitempty"`
+	Input  string               `json:"input,omitempty"`
+}
+
+// Message from the terminal
+type Input struct {
+	Type string `json:"type"`
+	Line string `json:"line,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+type Handler struct {
+	// Maximum number of simultaneous games, 0 = no limit
+	MaxSessions int
+	// Header configuration for every machine. Width and height may be
+	// overridden by the terminal.
+	Config zmachine.Config
+	// A game going over these in a turn is stopped, zmachine.DefaultLimits() by default
+	Limits zmachine.Limits
+	// Origins of the pages allowed to connect besides the handler's own
+	// host, "*" for any
+	AllowedOrigins []string
+
+	mu       sync.Mutex
+	stories  map[string][]byte
+	sessions int
+}
+
+func New() *Handler {
+	config := zmachine.DefaultConfig()
+	config.SplitScreen = true
+	config.Bold = true
+	config.Italic = true
+	config.FixedSpace = true
+
+	return &Handler{
+		Config:  config,
+		Limits:  zmachine.DefaultLimits(),
+		stories: make(map[string][]byte),
+	}
+}
+
+// AddStory makes a story available under name.
+func (h *Handler) AddStory(name string, story []byte) error {
+	story, err := zmachine.StoryBytes(story)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.stories[name] = story
+	h.mu.Unlock()
+	return nil
+}
+
+// Number of games being played
+func (h *Handler) Sessions() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("story")
+
+	h.mu.Lock()
+	story, ok := h.stories[name]
+	if name == "" && len(h.stories) == 1 {
+		for _, story = range h.stories {
+		}
+		ok = true
+	}
+	full := h.MaxSessions > 0 && h.sessions >= h.MaxSessions
+	if ok && !full {
+		// Taken now, so that simultaneous games can't go over the limit
+		h.sessions++
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		http.Error(w, fmt.Sprintf("no such story %q", name), http.StatusNotFound)
+		return
+	}
+	if full {
+		http.Error(w, "too many games, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+	defer func() {
+		h.mu.Lock()
+		h.sessions--
+		h.mu.Unlock()
+	}()
+
+	config := h.Config
+	if !screenSize(query.Get("width"), &config.ScreenWidth) ||
+		!screenSize(query.Get("height"), &config.ScreenHeight) {
+		http.Error(w, "invalid screen size", http.StatusBadRequest)
+		return
+	}
+
+	zm, err := zmachine.LoadBytes(story)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	zm.Configure(config)
+	zm.Limits = h.Limits
+
+	conn, err := Upgrade(w, r, h.AllowedOrigins...)
+	if err != nil {
+		return
+	}
+
+	play(r.Context(), conn, zm)
+}
+
+func screenSize(s string, size *uint8) bool {
+	if s == "" {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 255 {
+		return false
+	}
+	*size = uint8(n)
+	return true
+}
+
+// ZSCII codes of the named keys
+// Plays the game, running the machine whenever the terminal answers
+func play(ctx context.Context, conn *Conn, zm *zmachine.ZMachine) {
+	defer conn.Close()
+
+	term := &terminal{conn: conn}
+	zm.SetOutput(term)
+	zm.Storage = &zmachine.MemoryStorage{}
+
+	// Input typed key by key
+	var edit []byte
+
+	for run(ctx, term, zm) {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var in Input
+		if err := json.Unmarshal(msg, &in); err != nil {
+			continue
+		}
+
+		switch in.Type {
+		case "line":
+			zm.SendLine(in.Line)
+		case "key":
+			switch in.Key {
+			case "Enter":
+				term.event(&Event{Type: "text", Text: "\n"})
+				zm.SendLine(string(edit))
+				edit = edit[:0]
+			case "Backspace":
+				if len(edit) > 0 {
+					edit = edit[:len(edit)-1]
+					term.event(&Event{Type: "backspace"})
+				}
+			default:
+				r, size := utf8.DecodeRuneInString(in.Key)
+				if size == len(in.Key) && r >= 32 && r < 127 {
+					edit = append(edit, byte(r))
+					term.event(&Event{Type: "text", Text: in.Key})
+				}
+			}
+		}
+	}
+}
+
+// Runs the machine until it waits for input, false once the game is over.
+// Does nothing while the current input request isn't answered.
+func run(ctx context.Context, term *terminal, zm *zmachine.ZMachine) bool {
+	if zm.Waiting().Kind != zmachine.INPUT_NONE {
+		return true
+	}
+
+	_, err := zm.Run(ctx)
+	if err != nil {
+		term.send(&Event{Type: "error", Text: err.Error()})
+	}
+	if err != nil || zm.Done {
+		term.send(&Event{Type: "quit"})
+		return false
+	}
+
+	term.send(&Event{Type: "input", Input: "line"})
+	return true
+}
+
+// The machine's output. Text is collected until the next other event
+// or input request, so a turn usually goes out as a single text event.
+type terminal struct {
+	conn *Conn
+	text bytes.Buffer
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	t.text.Write(p)
+	return len(p), nil
+}
+
+func (t *terminal) flush() {
+	if t.text.Len() > 0 {
+		t.event(&Event{Type: "text", Text: t.text.String()})
+		t.text.Reset()
+	}
+}
+
+// Sends an event after the pending text
+func (t *terminal) send(e *Event) {
+	t.flush()
+	t.event(e)
+}
+
+// Sends an event right away
+func (t *terminal) event(e *Event) {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	// A closed terminal is noticed by the reading side
+	t.conn.WriteMessage(msg)
+}
+
+func (t *terminal) SplitWindow(lines int) {
+	t.send(&Event{Type: "split", Value: lines})
+}
+
+func (t *terminal) SetWindow(window int) {
+	t.send(&Event{Type: "window", Value: window})
+}
+
+func (t *terminal) ShowStatus(s zmachine.StatusLine) {
+	t.send(&Event{Type: "status", Status: &s})
+}
@@ -0,0 +1,196 @@
+package wsterm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awgh/zmachine/walkthrough"
+)
+
+func miniStory(t *testing.T) []byte {
+	story, err := walkthrough.ReadStory(filepath.Join("..", "bench", "stories", "mini.zas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return story
+}
+
+// Serves mini.zas, returns the URL to dial
+func serve(t *testing.T, h *Handler) string {
+	if err := h.AddStory("mini", miniStory(t)); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/?story=mini"
+}
+
+func dial(t *testing.T, url string) *Client {
+	c, err := DialGame(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Text, last status and last input request of a turn
+func readTurn(t *testing.T, c *Client) (string, *Event, string) {
+	events, err := c.ReadTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	var status *Event
+	input := ""
+	for _, e := range events {
+		switch e.Type {
+		case "text":
+			text.WriteString(e.Text)
+		case "status":
+			status = e
+		case "input":
+			input = e.Input
+		case "quit":
+			input = "quit"
+		case "error":
+			t.Fatalf("game error: %s", e.Text)
+		}
+	}
+	return text.String(), status, input
+}
+
+func TestPlay(t *testing.T) {
+	c := dial(t, serve(t, New()))
+
+	text, status, input := readTurn(t, c)
+	if !strings.Contains(text, "Mini test story") || input != "line" {
+		t.Fatalf("first turn: %q, input %q", text, input)
+	}
+	if status == nil || status.Status.Location != "West of House" {
+		t.Fatalf("status %+v", status)
+	}
+
+	// A restored game carries on after its save instruction
+	for _, turn := range []struct {
+		command, text, location string
+		score                   int16
+	}{
+		{"take lamp", "Taken.", "West of House", 5},
+		{"save", "Ok.", "West of House", 5},
+		{"north", "North of House", "North of House", 5},
+		{"restore", "Ok.", "West of House", 5},
+	} {
+		c.SendLine(turn.command)
+		text, status, _ := readTurn(t, c)
+		if !strings.Contains(text, turn.text) {
+			t.Errorf("%s: got %q, want %q", turn.command, text, turn.text)
+		}
+		if status == nil || status.Status.Location != turn.location || status.Status.Score != turn.score {
+			t.Errorf("%s: status %+v", turn.command, status)
+		}
+	}
+
+	c.SendLine("quit")
+	text, _, input = readTurn(t, c)
+	if !strings.Contains(text, "Score: 5") || input != "quit" {
+		t.Errorf("quit: %q, input %q", text, input)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	c := dial(t, serve(t, New()))
+	readTurn(t, c)
+
+	for _, key := range []string{"l", "x", "Backspace", "o", "o", "k"} {
+		c.SendKey(key)
+	}
+	c.SendKey("Enter")
+	var echo strings.Builder
+	backspaces := 0
+	for {
+		e, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "backspace" {
+			backspaces++
+		}
+		if e.Type == "text" {
+			echo.WriteString(e.Text)
+		}
+		if e.Type == "input" {
+			break
+		}
+	}
+	if backspaces != 1 || !strings.HasPrefix(echo.String(), "lxook\nWest of House") {
+		t.Errorf("echo %q, %d backspaces", echo.String(), backspaces)
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	h := New()
+	h.MaxSessions = 1
+	url := serve(t, h)
+
+	first := dial(t, url)
+	readTurn(t, first)
+	if _, err := DialGame(url); err != ErrBadHandshake {
+		t.Fatalf("second game: %v, want ErrBadHandshake", err)
+	}
+
+	first.Close()
+	for deadline := time.Now().Add(5 * time.Second); h.Sessions() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("game not ended after the terminal closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	readTurn(t, dial(t, url))
+}
+
+func TestOrigin(t *testing.T) {
+	h := New()
+	h.AddStory("mini", miniStory(t))
+
+	for _, test := range []struct {
+		origin  string
+		allowed []string
+		ok      bool
+	}{
+		{"", nil, true},
+		{"http://example.com", nil, true},
+		{"https://EXAMPLE.com", nil, true},
+		{"http://evil.example", nil, false},
+		{"http://evil.example", []string{"http://evil.example"}, true},
+		{"http://evil.example", []string{"*"}, true},
+		{"http://other.example", []string{"http://evil.example"}, false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/?story=mini", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-Websocket-Version", "13")
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := checkOrigin(r, test.allowed); got != test.ok {
+			t.Errorf("origin %q allowing %v: %v, want %v", test.origin, test.allowed, got, test.ok)
+		}
+
+		h.AllowedOrigins = test.allowed
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		// The recorder can't be hijacked, so allowed requests fail later
+		if forbidden := w.Code == http.StatusForbidden; forbidden == test.ok {
+			t.Errorf("origin %q allowing %v: status %d", test.origin, test.allowed, w.Code)
+		}
+	}
+	if h.Sessions() != 0 {
+		t.Errorf("%d games left", h.Sessions())
+	}
+}
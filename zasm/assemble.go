@@ -0,0 +1,662 @@
+package zasm
+
+import (
+	"bytes"
+	"sort"
+)
+
+const (
+	typeLarge    = 0
+	typeSmall    = 1
+	typeVariable = 2
+	typeOmitted  = 3
+)
+
+type symbolKind int
+
+const (
+	symRoutine symbolKind = iota
+	symString
+	symObject
+	symArray
+)
+
+type symbol struct {
+	kind  symbolKind
+	value int
+}
+
+type assembler struct {
+	s        *Story
+	mem      []byte
+	symbols  map[string]symbol
+	globals  map[string]int
+	words    map[string]int
+	packing  int
+	routine  *Routine
+	locals   map[string]int
+	labels   map[string]int
+	allLabel map[*Routine]map[string]int
+	emitting bool
+}
+
+// Assemble lays out the story and returns the story file image.
+func (s *Story) Assemble() ([]byte, error) {
+	if s.Version < 3 || s.Version > 5 {
+		return nil, errorf(0, "unsupported version %d", s.Version)
+	}
+	a := &assembler{
+		s:       s,
+		symbols: make(map[string]symbol),
+		globals: make(map[string]int),
+		words:   make(map[string]int),
+		packing: 2,
+
+		allLabel: make(map[*Routine]map[string]int),
+	}
+	if s.Version > 3 {
+		a.packing = 4
+	}
+	return a.assemble()
+}
+
+func (a *assembler) assemble() ([]byte, error) {
+	s := a.s
+	v3 := s.Version <= 3
+
+	if len(s.globals) > 240 {
+		return nil, errorf(0, "too many globals")
+	}
+	for i, g := range s.globals {
+		if _, dup := a.globals[g.name]; dup {
+			return nil, errorf(0, "duplicate global %q", g.name)
+		}
+		a.globals[g.name] = 0x10 + i
+	}
+	maxObjects, entrySize, numDefaults, attrBytes := 255, 9, 31, 4
+	if !v3 {
+		maxObjects, entrySize, numDefaults, attrBytes = 65535, 14, 63, 6
+	}
+	if len(s.objects) > maxObjects {
+		return nil, errorf(0, "too many objects")
+	}
+	for i, o := range s.objects {
+		o.number = i + 1
+		if err := a.define(o.Name, symbol{symObject, o.number}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Header and an empty abbreviation table
+	mem := make([]byte, 0x40+96*2)
+	abbrevAddr := 0x40
+
+	// Object table
+	objAddr := len(mem)
+	mem = append(mem, make([]byte, numDefaults*2+len(s.objects)*entrySize)...)
+	for prop, value := range s.defaults {
+		if prop < 1 || prop > numDefaults {
+			return nil, errorf(0, "invalid default property %d", prop)
+		}
+		putWord(mem, objAddr+(prop-1)*2, value)
+	}
+	children := make(map[string][]*Object)
+	for _, o := range s.objects {
+		if o.Parent != "" {
+			children[o.Parent] = append(children[o.Parent], o)
+		}
+	}
+	for _, o := range s.objects {
+		entry := objAddr + numDefaults*2 + (o.number-1)*entrySize
+		for _, attr := range o.Attributes {
+			if attr < 0 || attr >= attrBytes*8 {
+				return nil, errorf(0, "object %s: invalid attribute %d", o.Name, attr)
+			}
+			mem[entry+attr/8] |= 0x80 >> uint(attr%8)
+		}
+		parent, sibling, child := 0, 0, 0
+		if o.Parent != "" {
+			p, ok := a.symbols[o.Parent]
+			if !ok || p.kind != symObject {
+				return nil, errorf(0, "object %s: unknown parent %q", o.Name, o.Parent)
+			}
+			parent = p.value
+			siblings := children[o.Parent]
+			for i, sib := range siblings {
+				if sib == o && i+1 < len(siblings) {
+					sibling = siblings[i+1].number
+				}
+			}
+		}
+		if kids := children[o.Name]; len(kids) > 0 {
+			child = kids[0].number
+		}
+		if v3 {
+			mem[entry+4], mem[entry+5], mem[entry+6] = uint8(parent), uint8(sibling), uint8(child)
+		} else {
+			putWord(mem, entry+6, parent)
+			putWord(mem, entry+8, sibling)
+			putWord(mem, entry+10, child)
+		}
+	}
+	propAddrs := make([]int, len(s.objects))
+	for i, o := range s.objects {
+		propAddrs[i] = len(mem)
+		name := []byte{}
+		if o.ShortName != "" {
+			name = EncodeString(o.ShortName)
+		}
+		mem = append(mem, uint8(len(name)/2))
+		mem = append(mem, name...)
+		nums := make([]int, 0, len(o.props))
+		for n := range o.props {
+			nums = append(nums, n)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(nums)))
+		for _, n := range nums {
+			size := 0
+			for _, item := range o.props[n] {
+				size += item.size
+			}
+			switch {
+			case n < 1 || n > numDefaults:
+				return nil, errorf(0, "object %s: invalid property %d", o.Name, n)
+			case v3 && (size < 1 || size > 8):
+				return nil, errorf(0, "object %s: property %d has %d bytes", o.Name, n, size)
+			case !v3 && (size < 1 || size > 64):
+				return nil, errorf(0, "object %s: property %d has %d bytes", o.Name, n, size)
+			case v3:
+				mem = append(mem, uint8(32*(size-1)+n))
+			case size <= 2:
+				b := uint8(n)
+				if size == 2 {
+					b |= 0x40
+				}
+				mem = append(mem, b)
+			default:
+				mem = append(mem, uint8(0x80|n), uint8(0x80|(size&0x3F)))
+			}
+			// Values are filled in once every symbol is known
+			mem = append(mem, make([]byte, size)...)
+		}
+		mem = append(mem, 0)
+	}
+	for i := range s.objects {
+		entry := objAddr + numDefaults*2 + i*entrySize
+		if v3 {
+			putWord(mem, entry+7, propAddrs[i])
+		} else {
+			putWord(mem, entry+12, propAddrs[i])
+		}
+	}
+
+	// Globals and arrays
+	globalAddr := len(mem)
+	mem = append(mem, make([]byte, 480)...)
+	arrayAddrs := make([]int, len(s.arrays))
+	for i, arr := range s.arrays {
+		arrayAddrs[i] = len(mem)
+		if err := a.define(arr.name, symbol{symArray, len(mem)}); err != nil {
+			return nil, err
+		}
+		for _, item := range arr.items {
+			mem = append(mem, make([]byte, item.size)...)
+		}
+	}
+	if len(mem)%2 != 0 {
+		mem = append(mem, 0)
+	}
+
+	// Static memory: the dictionary
+	staticAddr := len(mem)
+	dictAddr := len(mem)
+	mem = append(mem, uint8(len(s.Separators)))
+	mem = append(mem, s.Separators...)
+	wordLen := 4
+	if !v3 {
+		wordLen = 6
+	}
+	entryLen := wordLen + s.WordDataBytes
+	mem = append(mem, uint8(entryLen))
+	mem = append(mem, 0, 0)
+	putWord(mem, len(mem)-2, len(s.words))
+	type encodedWord struct {
+		enc  []byte
+		word dictWord
+	}
+	var encoded []encodedWord
+	for _, w := range s.words {
+		enc := EncodeWord(w.text, s.Version)
+		for _, e := range encoded {
+			if bytes.Equal(e.enc, enc) {
+				return nil, errorf(0, "duplicate dictionary word %q", w.text)
+			}
+		}
+		if len(w.data) > s.WordDataBytes {
+			return nil, errorf(0, "word %q has too many data bytes", w.text)
+		}
+		encoded = append(encoded, encodedWord{enc, w})
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i].enc, encoded[j].enc) < 0 })
+	for _, e := range encoded {
+		a.words[e.word.text] = len(mem)
+		mem = append(mem, e.enc...)
+		data := make([]byte, s.WordDataBytes)
+		for i, d := range e.word.data {
+			data[i] = uint8(d)
+		}
+		mem = append(mem, data...)
+	}
+
+	// High memory: routines then strings, at packed addresses
+	a.align(&mem)
+	hiAddr := len(mem)
+	routineAddrs := make([]int, len(s.routines))
+	sizes := make([]int, len(s.routines))
+	for i, r := range s.routines {
+		a.align(&mem)
+		routineAddrs[i] = len(mem)
+		if err := a.define(r.Name, symbol{symRoutine, len(mem) / a.packing}); err != nil {
+			return nil, err
+		}
+		// First pass: sizes and label offsets
+		code, err := a.routineCode(r, len(mem))
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = len(code)
+		mem = append(mem, make([]byte, len(code))...)
+	}
+	for _, str := range s.strings {
+		a.align(&mem)
+		if err := a.define(str.name, symbol{symString, len(mem) / a.packing}); err != nil {
+			return nil, err
+		}
+		mem = append(mem, EncodeString(str.text)...)
+	}
+	for len(mem)%a.packing != 0 {
+		mem = append(mem, 0)
+	}
+
+	// Second pass: everything is known, emit code and data
+	a.emitting = true
+	for i, r := range s.routines {
+		code, err := a.routineCode(r, routineAddrs[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(code) != sizes[i] {
+			return nil, errorf(0, "routine %s changed size", r.Name)
+		}
+		copy(mem[routineAddrs[i]:], code)
+	}
+	a.routine = nil
+	for i, g := range s.globals {
+		v, err := a.dataValue(g.value, 0)
+		if err != nil {
+			return nil, err
+		}
+		putWord(mem, globalAddr+i*2, v)
+	}
+	for i, arr := range s.arrays {
+		addr := arrayAddrs[i]
+		for _, item := range arr.items {
+			v, err := a.dataValue(item.value, 0)
+			if err != nil {
+				return nil, err
+			}
+			if item.size == 1 {
+				mem[addr] = uint8(v)
+			} else {
+				putWord(mem, addr, v)
+			}
+			addr += item.size
+		}
+	}
+	for i, o := range s.objects {
+		addr := propAddrs[i] + 1 + int(mem[propAddrs[i]])*2
+		for mem[addr] != 0 {
+			sizeByte := mem[addr]
+			n := int(sizeByte & 0x1F)
+			if !v3 {
+				n = int(sizeByte & 0x3F)
+			}
+			addr++
+			if !v3 && sizeByte&0x80 != 0 {
+				addr++
+			}
+			for _, item := range o.props[n] {
+				v, err := a.dataValue(item.value, 0)
+				if err != nil {
+					return nil, err
+				}
+				if item.size == 1 {
+					mem[addr] = uint8(v)
+				} else {
+					putWord(mem, addr, v)
+				}
+				addr += item.size
+			}
+		}
+	}
+
+	main, ok := a.symbols[s.Main]
+	if !ok || main.kind != symRoutine {
+		return nil, errorf(0, "main routine %q not found", s.Main)
+	}
+	for _, r := range s.routines {
+		if r.Name == s.Main && len(r.Locals) > 0 {
+			return nil, errorf(0, "main routine %q cannot have locals", s.Main)
+		}
+	}
+	pc := main.value*a.packing + 1
+	if len(mem) > 0x3FFFF || (v3 && len(mem) > 0x1FFFF) {
+		return nil, errorf(0, "story too large")
+	}
+
+	mem[0] = s.Version
+	putWord(mem, 0x2, int(s.Release))
+	putWord(mem, 0x4, hiAddr)
+	putWord(mem, 0x6, pc)
+	putWord(mem, 0x8, dictAddr)
+	putWord(mem, 0xA, objAddr)
+	putWord(mem, 0xC, globalAddr)
+	putWord(mem, 0xE, staticAddr)
+	serial := []byte(s.Serial + "000000")
+	copy(mem[0x12:0x18], serial[:6])
+	putWord(mem, 0x18, abbrevAddr)
+	putWord(mem, 0x1A, len(mem)/a.packing)
+	checksum := 0
+	for _, b := range mem[0x40:] {
+		checksum += int(b)
+	}
+	putWord(mem, 0x1C, checksum)
+
+	return mem, nil
+}
+
+func (a *assembler) define(name string, sym symbol) error {
+	if name == "" {
+		return nil
+	}
+	if _, dup := a.symbols[name]; dup {
+		return errorf(0, "duplicate symbol %q", name)
+	}
+	if _, dup := a.globals[name]; dup {
+		return errorf(0, "symbol %q is already a global", name)
+	}
+	a.symbols[name] = sym
+	return nil
+}
+
+func (a *assembler) align(mem *[]byte) {
+	for len(*mem)%a.packing != 0 {
+		*mem = append(*mem, 0)
+	}
+}
+
+func putWord(mem []byte, addr int, v int) {
+	mem[addr] = uint8(v >> 8)
+	mem[addr+1] = uint8(v)
+}
+
+// Resolves an operand used as data (globals, arrays, properties)
+func (a *assembler) dataValue(op Operand, line int) (int, error) {
+	switch op.kind {
+	case opConst:
+		return op.value & 0xFFFF, nil
+	case opWord:
+		addr, ok := a.words[op.name]
+		if !ok {
+			return 0, errorf(line, "unknown dictionary word '%s'", op.name)
+		}
+		return addr, nil
+	case opName:
+		if sym, ok := a.symbols[op.name]; ok {
+			return sym.value, nil
+		}
+		if !a.emitting {
+			return 0, nil
+		}
+		return 0, errorf(line, "unknown symbol %q", op.name)
+	}
+	return 0, errorf(line, "variables cannot be used as data")
+}
+
+// Returns the variable number if the operand names a variable
+func (a *assembler) variable(op Operand) (int, bool) {
+	switch op.kind {
+	case opVar:
+		return op.value, true
+	case opName:
+		if n, ok := a.locals[op.name]; ok {
+			return n, true
+		}
+		if n, ok := a.globals[op.name]; ok {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+type encodedOperand struct {
+	typ   int
+	value int
+}
+
+func (a *assembler) operand(op Operand, in *Instruction, addr int) (encodedOperand, error) {
+	if n, ok := a.variable(op); ok {
+		if n < 0 || n > 255 {
+			return encodedOperand{}, errorf(in.line, "invalid variable %d", n)
+		}
+		return encodedOperand{typeVariable, n}, nil
+	}
+	if op.kind == opConst {
+		v := op.value & 0xFFFF
+		if op.value >= 0 && op.value <= 255 {
+			return encodedOperand{typeSmall, v}, nil
+		}
+		return encodedOperand{typeLarge, v}, nil
+	}
+	if op.kind == opName {
+		if target, ok := a.labels[op.name]; ok && in.op == "jump" {
+			// Filled in by the caller once the instruction length is known
+			return encodedOperand{typeLarge, target}, nil
+		}
+	}
+	v, err := a.dataValue(op, in.line)
+	return encodedOperand{typeLarge, v}, err
+}
+
+func (a *assembler) routineCode(r *Routine, start int) ([]byte, error) {
+	a.routine = r
+	a.locals = make(map[string]int)
+	if len(r.Locals) > 15 {
+		return nil, errorf(0, "routine %s has too many locals", r.Name)
+	}
+	for i, l := range r.Locals {
+		a.locals[l] = i + 1
+	}
+	code := []byte{uint8(len(r.Locals))}
+	if a.s.Version <= 4 {
+		for i := range r.Locals {
+			v := 0
+			if i < len(r.Init) {
+				v = r.Init[i]
+			}
+			code = append(code, uint8(v>>8), uint8(v))
+		}
+	}
+	if !a.emitting {
+		a.allLabel[r] = make(map[string]int)
+	}
+	a.labels = a.allLabel[r]
+	for _, in := range r.body {
+		if in.label != "" {
+			if !a.emitting {
+				if _, dup := a.labels[in.label]; dup {
+					return nil, errorf(in.line, "duplicate label %q", in.label)
+				}
+				a.labels[in.label] = start + len(code)
+			}
+			continue
+		}
+		b, err := a.instruction(in, start+len(code))
+		if err != nil {
+			return nil, err
+		}
+		code = append(code, b...)
+	}
+	return code, nil
+}
+
+func (a *assembler) instruction(in *Instruction, addr int) ([]byte, error) {
+	info, ok := findOpcode(in.op, a.s.Version)
+	if !ok {
+		return nil, errorf(in.line, "unknown opcode %q for version %d", in.op, a.s.Version)
+	}
+	ops := make([]encodedOperand, len(in.args))
+	for i, arg := range in.args {
+		if i == 0 && info.varRef {
+			if n, ok := a.variable(arg); ok {
+				ops[i] = encodedOperand{typeSmall, n}
+				continue
+			}
+		}
+		op, err := a.operand(arg, in, addr)
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = op
+	}
+
+	var code []byte
+	switch info.class {
+	case class0OP:
+		if len(ops) != 0 {
+			return nil, errorf(in.line, "%s takes no operands", in.op)
+		}
+		code = append(code, 0xB0|info.number)
+	case class1OP:
+		if len(ops) != 1 {
+			return nil, errorf(in.line, "%s takes one operand", in.op)
+		}
+		code = append(code, 0x80|uint8(ops[0].typ<<4)|info.number)
+	case class2OP:
+		if len(ops) < 2 || (len(ops) > 2 && in.op != "je") || len(ops) > 4 {
+			return nil, errorf(in.line, "%s takes two operands", in.op)
+		}
+		if len(ops) == 2 && ops[0].typ != typeLarge && ops[1].typ != typeLarge {
+			b := info.number
+			if ops[0].typ == typeVariable {
+				b |= 0x40
+			}
+			if ops[1].typ == typeVariable {
+				b |= 0x20
+			}
+			code = append(code, b)
+		} else {
+			code = append(code, 0xC0|info.number)
+			code = append(code, typesByte(ops)...)
+		}
+	case classVAR:
+		max := 4
+		if in.op == "call_vs2" || in.op == "call_vn2" {
+			max = 8
+		}
+		if len(ops) > max {
+			return nil, errorf(in.line, "%s takes at most %d operands", in.op, max)
+		}
+		code = append(code, 0xE0|info.number)
+		types := typesByte(ops)
+		if max == 8 && len(types) == 1 {
+			types = append(types, 0xFF)
+		}
+		code = append(code, types...)
+	case classEXT:
+		if len(ops) > 4 {
+			return nil, errorf(in.line, "%s takes at most 4 operands", in.op)
+		}
+		code = append(code, 0xBE, info.number)
+		code = append(code, typesByte(ops)...)
+	}
+
+	for _, op := range ops {
+		if op.typ == typeLarge {
+			code = append(code, uint8(op.value>>8), uint8(op.value))
+		} else {
+			code = append(code, uint8(op.value))
+		}
+	}
+	if in.op == "jump" && len(in.args) == 1 && in.args[0].kind == opName {
+		if target, ok := a.labels[in.args[0].name]; ok {
+			offset := target - (addr + len(code)) + 2
+			code[len(code)-2] = uint8(offset >> 8)
+			code[len(code)-1] = uint8(offset)
+		}
+	}
+
+	if info.store {
+		if in.store == nil {
+			return nil, errorf(in.line, "%s needs a store variable", in.op)
+		}
+		n, ok := a.variable(*in.store)
+		if !ok {
+			return nil, errorf(in.line, "%s stores to a non-variable", in.op)
+		}
+		code = append(code, uint8(n))
+	} else if in.store != nil {
+		return nil, errorf(in.line, "%s does not store a result", in.op)
+	}
+
+	if info.branch {
+		if !in.hasBranch {
+			return nil, errorf(in.line, "%s needs a branch target", in.op)
+		}
+		var b uint8
+		if in.branchOn {
+			b = 0x80
+		}
+		switch in.branch {
+		case "rfalse":
+			code = append(code, b|0x40)
+		case "rtrue":
+			code = append(code, b|0x40|1)
+		default:
+			offset := 0
+			if target, ok := a.labels[in.branch]; ok {
+				offset = target - (addr + len(code) + 2) + 2
+			} else if a.emitting {
+				return nil, errorf(in.line, "unknown label %q", in.branch)
+			}
+			if offset < -8192 || offset > 8191 {
+				return nil, errorf(in.line, "branch to %q out of range", in.branch)
+			}
+			code = append(code, b|uint8((offset>>8)&0x3F), uint8(offset))
+		}
+	} else if in.hasBranch {
+		return nil, errorf(in.line, "%s does not branch", in.op)
+	}
+
+	if info.text {
+		code = append(code, EncodeString(in.text)...)
+	} else if in.text != "" {
+		return nil, errorf(in.line, "%s does not take text", in.op)
+	}
+	return code, nil
+}
+
+func typesByte(ops []encodedOperand) []byte {
+	types := []byte{}
+	for i := 0; i < len(ops) || i%4 != 0 || i == 0; i++ {
+		if i%4 == 0 {
+			types = append(types, 0)
+		}
+		t := typeOmitted
+		if i < len(ops) {
+			t = ops[i].typ
+		}
+		types[i/4] |= uint8(t << uint(6-2*(i%4)))
+	}
+	return types
+}
@@ -0,0 +1,167 @@
+package zasm
+
+const (
+	class0OP = iota
+	class1OP
+	class2OP
+	classVAR
+	classEXT
+)
+
+type opcodeInfo struct {
+	name   string
+	class  int
+	number uint8
+	store  bool
+	branch bool
+	text   bool
+	// First operand names a variable and is encoded as a small constant
+	varRef     bool
+	minVersion uint8
+	maxVersion uint8
+}
+
+var opcodes = []opcodeInfo{
+	// 2OP
+	{name: "je", class: class2OP, number: 1, branch: true},
+	{name: "jl", class: class2OP, number: 2, branch: true},
+	{name: "jg", class: class2OP, number: 3, branch: true},
+	{name: "dec_chk", class: class2OP, number: 4, branch: true, varRef: true},
+	{name: "inc_chk", class: class2OP, number: 5, branch: true, varRef: true},
+	{name: "jin", class: class2OP, number: 6, branch: true},
+	{name: "test", class: class2OP, number: 7, branch: true},
+	{name: "or", class: class2OP, number: 8, store: true},
+	{name: "and", class: class2OP, number: 9, store: true},
+	{name: "test_attr", class: class2OP, number: 10, branch: true},
+	{name: "set_attr", class: class2OP, number: 11},
+	{name: "clear_attr", class: class2OP, number: 12},
+	{name: "store", class: class2OP, number: 13, varRef: true},
+	{name: "insert_obj", class: class2OP, number: 14},
+	{name: "loadw", class: class2OP, number: 15, store: true},
+	{name: "loadb", class: class2OP, number: 16, store: true},
+	{name: "get_prop", class: class2OP, number: 17, store: true},
+	{name: "get_prop_addr", class: class2OP, number: 18, store: true},
+	{name: "get_next_prop", class: class2OP, number: 19, store: true},
+	{name: "add", class: class2OP, number: 20, store: true},
+	{name: "sub", class: class2OP, number: 21, store: true},
+	{name: "mul", class: class2OP, number: 22, store: true},
+	{name: "div", class: class2OP, number: 23, store: true},
+	{name: "mod", class: class2OP, number: 24, store: true},
+	{name: "call_2s", class: class2OP, number: 25, store: true, minVersion: 4},
+	{name: "call_2n", class: class2OP, number: 26, minVersion: 5},
+	{name: "set_colour", class: class2OP, number: 27, minVersion: 5},
+	{name: "throw", class: class2OP, number: 28, minVersion: 5},
+
+	// 1OP
+	{name: "jz", class: class1OP, number: 0, branch: true},
+	{name: "get_sibling", class: class1OP, number: 1, store: true, branch: true},
+	{name: "get_child", class: class1OP, number: 2, store: true, branch: true},
+	{name: "get_parent", class: class1OP, number: 3, store: true},
+	{name: "get_prop_len", class: class1OP, number: 4, store: true},
+	{name: "inc", class: class1OP, number: 5, varRef: true},
+	{name: "dec", class: class1OP, number: 6, varRef: true},
+	{name: "print_addr", class: class1OP, number: 7},
+	{name: "call_1s", class: class1OP, number: 8, store: true, minVersion: 4},
+	{name: "remove_obj", class: class1OP, number: 9},
+	{name: "print_obj", class: class1OP, number: 10},
+	{name: "ret", class: class1OP, number: 11},
+	{name: "jump", class: class1OP, number: 12},
+	{name: "print_paddr", class: class1OP, number: 13},
+	{name: "load", class: class1OP, number: 14, store: true, varRef: true},
+	{name: "not", class: class1OP, number: 15, store: true, maxVersion: 4},
+	{name: "call_1n", class: class1OP, number: 15, minVersion: 5},
+
+	// 0OP
+	{name: "rtrue", class: class0OP, number: 0},
+	{name: "rfalse", class: class0OP, number: 1},
+	{name: "print", class: class0OP, number: 2, text: true},
+	{name: "print_ret", class: class0OP, number: 3, text: true},
+	{name: "nop", class: class0OP, number: 4},
+	{name: "save", class: class0OP, number: 5, branch: true, maxVersion: 3},
+	{name: "save", class: class0OP, number: 5, store: true, minVersion: 4, maxVersion: 4},
+	{name: "restore", class: class0OP, number: 6, branch: true, maxVersion: 3},
+	{name: "restore", class: class0OP, number: 6, store: true, minVersion: 4, maxVersion: 4},
+	{name: "restart", class: class0OP, number: 7},
+	{name: "ret_popped", class: class0OP, number: 8},
+	{name: "pop", class: class0OP, number: 9, maxVersion: 4},
+	{name: "catch", class: class0OP, number: 9, store: true, minVersion: 5},
+	{name: "quit", class: class0OP, number: 10},
+	{name: "new_line", class: class0OP, number: 11},
+	{name: "show_status", class: class0OP, number: 12, maxVersion: 3},
+	{name: "verify", class: class0OP, number: 13, branch: true},
+	{name: "piracy", class: class0OP, number: 15, branch: true, minVersion: 5},
+
+	// VAR
+	{name: "call", class: classVAR, number: 0, store: true},
+	{name: "call_vs", class: classVAR, number: 0, store: true},
+	{name: "storew", class: classVAR, number: 1},
+	{name: "storeb", class: classVAR, number: 2},
+	{name: "put_prop", class: classVAR, number: 3},
+	{name: "sread", class: classVAR, number: 4, maxVersion: 4},
+	{name: "aread", class: classVAR, number: 4, store: true, minVersion: 5},
+	{name: "print_char", class: classVAR, number: 5},
+	{name: "print_num", class: classVAR, number: 6},
+	{name: "random", class: classVAR, number: 7, store: true},
+	{name: "push", class: classVAR, number: 8},
+	{name: "pull", class: classVAR, number: 9, varRef: true},
+	{name: "split_window", class: classVAR, number: 10},
+	{name: "set_window", class: classVAR, number: 11},
+	{name: "call_vs2", class: classVAR, number: 12, store: true, minVersion: 4},
+	{name: "erase_window", class: classVAR, number: 13, minVersion: 4},
+	{name: "erase_line", class: classVAR, number: 14, minVersion: 4},
+	{name: "set_cursor", class: classVAR, number: 15, minVersion: 4},
+	{name: "get_cursor", class: classVAR, number: 16, minVersion: 4},
+	{name: "set_text_style", class: classVAR, number: 17, minVersion: 4},
+	{name: "buffer_mode", class: classVAR, number: 18, minVersion: 4},
+	{name: "output_stream", class: classVAR, number: 19},
+	{name: "input_stream", class: classVAR, number: 20},
+	{name: "sound_effect", class: classVAR, number: 21},
+	{name: "read_char", class: classVAR, number: 22, store: true, minVersion: 4},
+	{name: "scan_table", class: classVAR, number: 23, store: true, branch: true, minVersion: 4},
+	{name: "not", class: classVAR, number: 24, store: true, minVersion: 5},
+	{name: "call_vn", class: classVAR, number: 25, minVersion: 5},
+	{name: "call_vn2", class: classVAR, number: 26, minVersion: 5},
+	{name: "tokenise", class: classVAR, number: 27, minVersion: 5},
+	{name: "encode_text", class: classVAR, number: 28, minVersion: 5},
+	{name: "copy_table", class: classVAR, number: 29, minVersion: 5},
+	{name: "print_table", class: classVAR, number: 30, minVersion: 5},
+	{name: "check_arg_count", class: classVAR, number: 31, branch: true, minVersion: 5},
+
+	// EXT
+	{name: "save", class: classEXT, number: 0, store: true, minVersion: 5},
+	{name: "restore", class: classEXT, number: 1, store: true, minVersion: 5},
+	{name: "log_shift", class: classEXT, number: 2, store: true, minVersion: 5},
+	{name: "art_shift", class: classEXT, number: 3, store: true, minVersion: 5},
+	{name: "set_font", class: classEXT, number: 4, store: true, minVersion: 5},
+	{name: "save_undo", class: classEXT, number: 9, store: true, minVersion: 5},
+	{name: "restore_undo", class: classEXT, number: 10, store: true, minVersion: 5},
+	{name: "print_unicode", class: classEXT, number: 11, minVersion: 5},
+	{name: "check_unicode", class: classEXT, number: 12, store: true, minVersion: 5},
+}
+
+// Aliases accepted by the text syntax
+var opcodeAliases = map[string]string{
+	"read": "sread",
+}
+
+func findOpcode(name string, version uint8) (opcodeInfo, bool) {
+	if alias, ok := opcodeAliases[name]; ok {
+		name = alias
+		if version >= 5 {
+			name = "aread"
+		}
+	}
+	for _, op := range opcodes {
+		if op.name != name {
+			continue
+		}
+		if op.minVersion != 0 && version < op.minVersion {
+			continue
+		}
+		if op.maxVersion != 0 && version > op.maxVersion {
+			continue
+		}
+		return op, true
+	}
+	return opcodeInfo{}, false
+}
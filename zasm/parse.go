@@ -0,0 +1,388 @@
+package zasm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Parse reads a story in the text syntax:
+//
+//	.version 3
+//	.global score 0
+//	.object room "West of House"
+//	.attr 3
+//	.prop 18 $0102
+//	.word look 1 2
+//	.routine main
+//	    print "Hello"
+//	    call greet 1 -> sp
+//	    jz sp ?done
+//	done:
+//	    quit
+//	.routine greet n
+//	    print_num n
+//	    rtrue
+//
+// Comments start with ';'. Operands are numbers (decimal, $hex or 0xhex),
+// 'sp', local or global names, 'words' from the dictionary and names of
+// routines, strings, objects, arrays and labels. A store is written
+// "-> var" and a branch "?label", "?~label", "?rtrue" or "?rfalse".
+func Parse(src string) (*Story, error) {
+	s := NewStory(3)
+	var routine *Routine
+	var object *Object
+
+	for n, raw := range strings.Split(src, "\n") {
+		line := n + 1
+		tokens, err := tokenize(raw, line)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(tokens[0], ".") {
+			args := tokens[1:]
+			switch tokens[0] {
+			case ".version":
+				v, err := intArg(args, 0, line)
+				if err != nil {
+					return nil, err
+				}
+				s.Version = uint8(v)
+			case ".release":
+				v, err := intArg(args, 0, line)
+				if err != nil {
+					return nil, err
+				}
+				s.Release = uint16(v)
+			case ".serial":
+				str, err := stringArg(args, 0, line)
+				if err != nil {
+					return nil, err
+				}
+				s.Serial = str
+			case ".separators":
+				str, err := stringArg(args, 0, line)
+				if err != nil {
+					return nil, err
+				}
+				s.Separators = str
+			case ".main":
+				if len(args) != 1 {
+					return nil, errorf(line, ".main needs a routine name")
+				}
+				s.Main = args[0]
+			case ".global":
+				if len(args) < 1 || len(args) > 2 {
+					return nil, errorf(line, ".global needs a name and an optional value")
+				}
+				value := Const(0)
+				if len(args) == 2 {
+					if value, err = parseOperand(args[1], line); err != nil {
+						return nil, err
+					}
+				}
+				s.AddGlobal(args[0], value)
+			case ".default":
+				prop, err := intArg(args, 0, line)
+				if err != nil {
+					return nil, err
+				}
+				v, err := intArg(args, 1, line)
+				if err != nil {
+					return nil, err
+				}
+				s.SetDefault(prop, v)
+			case ".object":
+				if len(args) < 2 || len(args) > 3 {
+					return nil, errorf(line, ".object needs a name, a short name and an optional parent")
+				}
+				short, err := stringArg(args, 1, line)
+				if err != nil {
+					return nil, err
+				}
+				parent := ""
+				if len(args) == 3 {
+					parent = args[2]
+				}
+				object = s.AddObject(args[0], short, parent)
+			case ".attr":
+				if object == nil {
+					return nil, errorf(line, ".attr outside of an object")
+				}
+				for i := range args {
+					a, err := intArg(args, i, line)
+					if err != nil {
+						return nil, err
+					}
+					object.SetAttr(a)
+				}
+			case ".prop", ".propb":
+				if object == nil {
+					return nil, errorf(line, "%s outside of an object", tokens[0])
+				}
+				num, err := intArg(args, 0, line)
+				if err != nil {
+					return nil, err
+				}
+				values, err := parseOperands(args[1:], line)
+				if err != nil {
+					return nil, err
+				}
+				if len(values) == 0 {
+					return nil, errorf(line, "%s needs at least one value", tokens[0])
+				}
+				size := 2
+				if tokens[0] == ".propb" {
+					size = 1
+				}
+				items := make([]dataItem, len(values))
+				for i, v := range values {
+					items[i] = dataItem{size, v}
+				}
+				object.props[num] = items
+			case ".word":
+				if len(args) < 1 {
+					return nil, errorf(line, ".word needs a word")
+				}
+				var data []int
+				for i := 1; i < len(args); i++ {
+					d, err := intArg(args, i, line)
+					if err != nil {
+						return nil, err
+					}
+					data = append(data, d)
+				}
+				s.AddWord(strings.Trim(args[0], "'"), data...)
+			case ".bytes", ".words":
+				if len(args) < 1 {
+					return nil, errorf(line, "%s needs a name", tokens[0])
+				}
+				values, err := parseOperands(args[1:], line)
+				if err != nil {
+					return nil, err
+				}
+				if tokens[0] == ".bytes" {
+					s.AddBytes(args[0], values...)
+				} else {
+					s.AddWords(args[0], values...)
+				}
+			case ".space":
+				if len(args) != 2 {
+					return nil, errorf(line, ".space needs a name and a size")
+				}
+				size, err := intArg(args, 1, line)
+				if err != nil {
+					return nil, err
+				}
+				s.AddSpace(args[0], size)
+			case ".string":
+				if len(args) != 2 {
+					return nil, errorf(line, ".string needs a name and a string")
+				}
+				str, err := stringArg(args, 1, line)
+				if err != nil {
+					return nil, err
+				}
+				s.AddString(args[0], str)
+			case ".routine":
+				if len(args) < 1 {
+					return nil, errorf(line, ".routine needs a name")
+				}
+				routine = s.AddRoutine(args[0])
+				for _, l := range args[1:] {
+					name, init := l, 0
+					if i := strings.IndexByte(l, '='); i >= 0 {
+						name = l[:i]
+						if init, err = parseInt(l[i+1:], line); err != nil {
+							return nil, err
+						}
+					}
+					routine.Locals = append(routine.Locals, name)
+					routine.Init = append(routine.Init, init)
+				}
+				object = nil
+			case ".end":
+				routine = nil
+			default:
+				return nil, errorf(line, "unknown directive %s", tokens[0])
+			}
+			continue
+		}
+
+		if routine == nil {
+			return nil, errorf(line, "instruction outside of a routine")
+		}
+		if strings.HasSuffix(tokens[0], ":") {
+			routine.Label(strings.TrimSuffix(tokens[0], ":"))
+			routine.body[len(routine.body)-1].line = line
+			tokens = tokens[1:]
+			if len(tokens) == 0 {
+				continue
+			}
+		}
+
+		in := routine.Op(tokens[0])
+		in.line = line
+		for i := 1; i < len(tokens); i++ {
+			tok := tokens[i]
+			switch {
+			case tok == "->":
+				if i+1 >= len(tokens) {
+					return nil, errorf(line, "missing store variable")
+				}
+				i++
+				v, err := parseOperand(tokens[i], line)
+				if err != nil {
+					return nil, err
+				}
+				in.Store(v)
+			case strings.HasPrefix(tok, "?~"):
+				in.Branch(tok[2:], false)
+			case strings.HasPrefix(tok, "?"):
+				in.Branch(tok[1:], true)
+			case strings.HasPrefix(tok, "\""):
+				str, err := unquote(tok, line)
+				if err != nil {
+					return nil, err
+				}
+				in.Text(str)
+			default:
+				v, err := parseOperand(tok, line)
+				if err != nil {
+					return nil, err
+				}
+				in.args = append(in.args, v)
+			}
+		}
+	}
+	return s, nil
+}
+
+// Assemble parses and assembles a story in the text syntax.
+func Assemble(src string) ([]byte, error) {
+	s, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return s.Assemble()
+}
+
+func tokenize(raw string, line int) ([]string, error) {
+	var tokens []string
+	i := 0
+	for i < len(raw) {
+		c := raw[i]
+		switch {
+		case c == ';':
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(raw) && raw[j] != '"' {
+				if raw[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(raw) {
+				return nil, errorf(line, "unterminated string")
+			}
+			tokens = append(tokens, raw[i:j+1])
+			i = j + 1
+		case c == '\'':
+			j := strings.IndexByte(raw[i+1:], '\'')
+			if j < 0 {
+				return nil, errorf(line, "unterminated word")
+			}
+			tokens = append(tokens, raw[i:i+j+2])
+			i += j + 2
+		default:
+			j := i
+			for j < len(raw) && raw[j] != ' ' && raw[j] != '\t' && raw[j] != '\r' && raw[j] != ';' {
+				j++
+			}
+			tokens = append(tokens, raw[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func unquote(tok string, line int) (string, error) {
+	str, err := strconv.Unquote(tok)
+	if err != nil {
+		return "", errorf(line, "invalid string %s", tok)
+	}
+	return str, nil
+}
+
+func parseInt(tok string, line int) (int, error) {
+	var v int64
+	var err error
+	switch {
+	case strings.HasPrefix(tok, "$"):
+		v, err = strconv.ParseInt(tok[1:], 16, 32)
+	case strings.HasPrefix(tok, "-$"):
+		v, err = strconv.ParseInt(tok[2:], 16, 32)
+		v = -v
+	default:
+		v, err = strconv.ParseInt(tok, 0, 32)
+	}
+	if err != nil {
+		return 0, errorf(line, "invalid number %q", tok)
+	}
+	return int(v), nil
+}
+
+func isNumber(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	c := tok[0]
+	return c == '$' || c == '-' || (c >= '0' && c <= '9')
+}
+
+func parseOperand(tok string, line int) (Operand, error) {
+	switch {
+	case tok == "sp":
+		return SP, nil
+	case isNumber(tok):
+		v, err := parseInt(tok, line)
+		return Const(v), err
+	case strings.HasPrefix(tok, "'"):
+		return Word(strings.Trim(tok, "'")), nil
+	case strings.HasPrefix(tok, "\""):
+		return Operand{}, errorf(line, "unexpected string %s", tok)
+	}
+	return Ref(tok), nil
+}
+
+func parseOperands(tokens []string, line int) ([]Operand, error) {
+	ops := make([]Operand, len(tokens))
+	for i, tok := range tokens {
+		op, err := parseOperand(tok, line)
+		if err != nil {
+			return nil, err
+		}
+		ops[i] = op
+	}
+	return ops, nil
+}
+
+func intArg(args []string, i int, line int) (int, error) {
+	if i >= len(args) {
+		return 0, errorf(line, "missing argument")
+	}
+	return parseInt(args[i], line)
+}
+
+func stringArg(args []string, i int, line int) (string, error) {
+	if i >= len(args) {
+		return "", errorf(line, "missing argument")
+	}
+	return unquote(args[i], line)
+}
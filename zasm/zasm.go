@@ -0,0 +1,275 @@
+// Package zasm is a small Z-code assembler used to build story files for
+// testing the interpreter. Stories are described either through the Go API
+// (NewStory, AddRoutine, ...) or in a text syntax parsed by Parse.
+package zasm
+
+import (
+	"fmt"
+)
+
+type operandKind int
+
+const (
+	opConst operandKind = iota
+	opVar
+	opName
+	opWord
+)
+
+// Operand is an instruction operand, property value or table entry.
+type Operand struct {
+	kind  operandKind
+	value int
+	name  string
+}
+
+// Const is a numeric constant. Negative values are stored as 16-bit two's complement.
+func Const(v int) Operand {
+	return Operand{kind: opConst, value: v}
+}
+
+// Var is a variable by number: 0 = stack, 1-15 = locals, 16-255 = globals.
+func Var(n int) Operand {
+	return Operand{kind: opVar, value: n}
+}
+
+// SP is the top of the routine stack.
+var SP = Var(0)
+
+// Ref names a local, global, routine, string, object, array or label.
+// Routines and strings resolve to packed addresses, objects to their number,
+// arrays to their byte address and labels (for jump) to a jump offset.
+func Ref(name string) Operand {
+	return Operand{kind: opName, name: name}
+}
+
+// Word is the address of a dictionary entry.
+func Word(w string) Operand {
+	return Operand{kind: opWord, name: w}
+}
+
+type dataItem struct {
+	size  int
+	value Operand
+}
+
+// Object is an entry of the object table.
+type Object struct {
+	Name       string
+	ShortName  string
+	Parent     string
+	Attributes []int
+	props      map[int][]dataItem
+	number     int
+}
+
+// SetAttr sets the given attributes on the object.
+func (o *Object) SetAttr(attrs ...int) *Object {
+	o.Attributes = append(o.Attributes, attrs...)
+	return o
+}
+
+// SetProp sets property num to the given words.
+func (o *Object) SetProp(num int, words ...Operand) *Object {
+	items := make([]dataItem, len(words))
+	for i, w := range words {
+		items[i] = dataItem{2, w}
+	}
+	o.props[num] = items
+	return o
+}
+
+// SetPropBytes sets property num to the given bytes.
+func (o *Object) SetPropBytes(num int, bytes ...int) *Object {
+	items := make([]dataItem, len(bytes))
+	for i, b := range bytes {
+		items[i] = dataItem{1, Const(b)}
+	}
+	o.props[num] = items
+	return o
+}
+
+type global struct {
+	name  string
+	value Operand
+}
+
+type dictWord struct {
+	text string
+	data []int
+}
+
+type array struct {
+	name  string
+	items []dataItem
+}
+
+type namedString struct {
+	name string
+	text string
+}
+
+// Instruction is a single Z-machine instruction inside a routine.
+type Instruction struct {
+	op        string
+	args      []Operand
+	store     *Operand
+	hasBranch bool
+	branch    string
+	branchOn  bool
+	text      string
+	label     string
+	line      int
+}
+
+// Store sets the result variable of the instruction.
+func (in *Instruction) Store(v Operand) *Instruction {
+	in.store = &v
+	return in
+}
+
+// Branch sets the branch target. Label may also be "rtrue" or "rfalse".
+func (in *Instruction) Branch(label string, onTrue bool) *Instruction {
+	in.hasBranch = true
+	in.branch = label
+	in.branchOn = onTrue
+	return in
+}
+
+// Text sets the inline string of print and print_ret.
+func (in *Instruction) Text(s string) *Instruction {
+	in.text = s
+	return in
+}
+
+// Routine is a Z-code routine made of instructions and labels.
+type Routine struct {
+	Name   string
+	Locals []string
+	// Initial values of the locals, versions 1-4 only
+	Init []int
+	body []*Instruction
+}
+
+// Label defines a branch target at the current position.
+func (r *Routine) Label(name string) {
+	r.body = append(r.body, &Instruction{label: name})
+}
+
+// Op appends an instruction.
+func (r *Routine) Op(name string, args ...Operand) *Instruction {
+	in := &Instruction{op: name, args: args}
+	r.body = append(r.body, in)
+	return in
+}
+
+// Story describes a story file to be assembled.
+type Story struct {
+	Version uint8
+	Release uint16
+	// Six ASCII characters, traditionally the compile date as YYMMDD
+	Serial     string
+	Separators string
+	// Number of data bytes following each dictionary word
+	WordDataBytes int
+	// Routine where execution starts. It must not have locals.
+	Main string
+
+	globals  []global
+	defaults map[int]int
+	objects  []*Object
+	words    []dictWord
+	arrays   []array
+	strings  []namedString
+	routines []*Routine
+}
+
+// NewStory returns an empty story of the given version (3, 4 or 5).
+func NewStory(version uint8) *Story {
+	return &Story{
+		Version:       version,
+		Release:       1,
+		Serial:        "000000",
+		Separators:    ".,\"",
+		WordDataBytes: 3,
+		Main:          "main",
+		defaults:      make(map[int]int),
+	}
+}
+
+// AddGlobal declares the next global variable.
+func (s *Story) AddGlobal(name string, value Operand) {
+	s.globals = append(s.globals, global{name, value})
+}
+
+// SetDefault sets the default value of a property.
+func (s *Story) SetDefault(prop int, value int) {
+	s.defaults[prop] = value
+}
+
+// AddObject declares the next object. Parent may be empty for a root object.
+func (s *Story) AddObject(name, shortName, parent string) *Object {
+	o := &Object{Name: name, ShortName: shortName, Parent: parent, props: make(map[int][]dataItem)}
+	s.objects = append(s.objects, o)
+	return o
+}
+
+// AddWord adds a dictionary word with optional data bytes.
+func (s *Story) AddWord(text string, data ...int) {
+	s.words = append(s.words, dictWord{text, data})
+}
+
+// AddBytes declares a byte array in dynamic memory.
+func (s *Story) AddBytes(name string, values ...Operand) {
+	items := make([]dataItem, len(values))
+	for i, v := range values {
+		items[i] = dataItem{1, v}
+	}
+	s.arrays = append(s.arrays, array{name, items})
+}
+
+// AddWords declares a word array in dynamic memory.
+func (s *Story) AddWords(name string, values ...Operand) {
+	items := make([]dataItem, len(values))
+	for i, v := range values {
+		items[i] = dataItem{2, v}
+	}
+	s.arrays = append(s.arrays, array{name, items})
+}
+
+// AddSpace declares n zero bytes in dynamic memory.
+func (s *Story) AddSpace(name string, n int) {
+	s.arrays = append(s.arrays, array{name, make([]dataItem, n)})
+	for i := range s.arrays[len(s.arrays)-1].items {
+		s.arrays[len(s.arrays)-1].items[i] = dataItem{1, Const(0)}
+	}
+}
+
+// AddString declares a string in high memory, for print_paddr.
+func (s *Story) AddString(name, text string) {
+	s.strings = append(s.strings, namedString{name, text})
+}
+
+// AddRoutine declares a routine with the given local variable names.
+func (s *Story) AddRoutine(name string, locals ...string) *Routine {
+	r := &Routine{Name: name, Locals: locals}
+	s.routines = append(s.routines, r)
+	return r
+}
+
+// Error is an assembly error, with the source line when known.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("zasm: line %d: %s", e.Line, e.Msg)
+	}
+	return "zasm: " + e.Msg
+}
+
+func errorf(line int, format string, v ...interface{}) error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, v...)}
+}
@@ -0,0 +1,313 @@
+package zasm_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/awgh/zmachine"
+	"github.com/awgh/zmachine/zasm"
+)
+
+const source = `
+.version 3
+.release 2
+.serial "261018"
+.global counter 5
+.object room "Room"
+.attr 1
+.prop 7 $1234
+.object box "box" room
+.propb 9 1 2
+.word box 128
+.string hello "Hello, world!"
+.bytes buf 1 2 3
+.routine main
+    print_paddr hello
+    call add2 counter -> counter
+    jz counter ?done
+    print_num counter
+done:
+    quit
+.routine add2 n
+    add n 2 -> sp
+    ret_popped
+`
+
+// Same story as source, through the Go API
+func apiStory() *zasm.Story {
+	s := zasm.NewStory(3)
+	s.Release = 2
+	s.Serial = "261018"
+	s.AddGlobal("counter", zasm.Const(5))
+	s.AddObject("room", "Room", "").SetAttr(1).SetProp(7, zasm.Const(0x1234))
+	s.AddObject("box", "box", "room").SetPropBytes(9, 1, 2)
+	s.AddWord("box", 128)
+	s.AddString("hello", "Hello, world!")
+	s.AddBytes("buf", zasm.Const(1), zasm.Const(2), zasm.Const(3))
+
+	main := s.AddRoutine("main")
+	main.Op("print_paddr", zasm.Ref("hello"))
+	main.Op("call", zasm.Ref("add2"), zasm.Ref("counter")).Store(zasm.Ref("counter"))
+	main.Op("jz", zasm.Ref("counter")).Branch("done", true)
+	main.Op("print_num", zasm.Ref("counter"))
+	main.Label("done")
+	main.Op("quit")
+
+	add2 := s.AddRoutine("add2", "n")
+	add2.Op("add", zasm.Ref("n"), zasm.Const(2)).Store(zasm.SP)
+	add2.Op("ret_popped")
+	return s
+}
+
+// Runs the story until it stops, with the output
+func run(t *testing.T, story []byte) (*zmachine.ZMachine, string, error) {
+	zm, err := zmachine.LoadBytes(story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	zm.SetOutput(&output)
+	_, err = zm.RunUntilInput()
+	return zm, output.String(), err
+}
+
+func TestParse(t *testing.T) {
+	parsed, err := zasm.Assemble(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := apiStory().Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed, built) {
+		t.Error("the text syntax and the API assemble different stories")
+	}
+
+	for _, bad := range []string{
+		".routine main\n    frobnicate 1\n",
+		".routine main\n    jump nowhere\n",
+		".global g 1\n.global g 2\n.routine main\n    quit\n",
+		".version 3\n    quit\n",
+		".routine main\n    print \"unterminated\n",
+	} {
+		if _, err := zasm.Assemble(bad); err == nil {
+			t.Errorf("assembled %q", bad)
+		}
+	}
+}
+
+// What the interpreter reads back is what was assembled
+func TestRoundTrip(t *testing.T) {
+	story, err := zasm.Assemble(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zm, output, err := run(t, story)
+	if err != nil || !zm.Done || output != "Hello, world!7" {
+		t.Fatalf("output %q, done %v, error %v", output, zm.Done, err)
+	}
+
+	if id := zm.StoryID(); id != "2-261018" {
+		t.Errorf("story ID %q", id)
+	}
+	if zm.NumObjects() != 2 || zm.GetObjectName(1) != "Room" || zm.GetObjectName(2) != "box" {
+		t.Errorf("%d objects, %q and %q", zm.NumObjects(), zm.GetObjectName(1), zm.GetObjectName(2))
+	}
+	if zm.GetParentObject(2) != 1 || zm.GetFirstChild(1) != 2 {
+		t.Errorf("box in %d, room holding %d", zm.GetParentObject(2), zm.GetFirstChild(1))
+	}
+	if !zm.TestObjectAttr(1, 1) || zm.TestObjectAttr(2, 1) {
+		t.Error("attribute 1 not only on the room")
+	}
+	if p := zm.GetObjectProperty(1, 7); p != 0x1234 {
+		t.Errorf("room property 7 = 0x%X", p)
+	}
+	if addr, size := zm.GetObjectPropertyInfo(2, 9); size != 2 || zm.LoadByte(uint32(addr)+1) != 2 {
+		t.Errorf("box property 9 of %d bytes", size)
+	}
+	if addr := zm.FindInDictionary("box"); addr == zmachine.DICT_NOT_FOUND || zm.LoadByte(uint32(addr)+4) != 128 {
+		t.Error("box not in the dictionary with its data")
+	}
+	if counter := zm.ReadGlobal(0x10); counter != 7 {
+		t.Errorf("counter %d", counter)
+	}
+}
+
+// The interpreter decodes what EncodeString encoded
+func TestEncodeString(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"plain lower case",
+		"Mixed Case, Digits 0123456789 and .,!?_#'\"/\\-:()",
+		"two\nlines",
+		"escapes: @ { } [ ] ~ * < >",
+		"a",
+		"ab",
+		"abc",
+	} {
+		s := zasm.NewStory(3)
+		s.AddString("text", text)
+		main := s.AddRoutine("main")
+		main.Op("print_paddr", zasm.Ref("text"))
+		main.Op("quit")
+		story, err := s.Assemble()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, output, err := run(t, story); err != nil || output != text {
+			t.Errorf("printed %q, want %q (%v)", output, text, err)
+		}
+		if n := len(zasm.EncodeString(text)); n%2 != 0 || n == 0 {
+			t.Errorf("%q encoded to %d bytes", text, n)
+		}
+	}
+}
+
+// Snippets run in main, with a global for results and a table
+const prelude = `
+.version 3
+.global result 0
+.global table buf
+.space buf 8
+.object box "box"
+.attr 2
+.prop 10 $0102
+.prop 5 7
+.object coin "coin" box
+.object bag "bag"
+.string greeting "Hi"
+.routine double n
+    mul n 2 -> sp
+    ret_popped
+.routine three a b=7 c
+    print_num a
+    print_char 32
+    print_num b
+    print_char 32
+    print_num c
+    rtrue
+.routine bad a
+    inc 3
+    rtrue
+.routine main
+%s
+    quit
+`
+
+func TestOpcodes(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		code   string
+		output string
+		result uint16
+		// Start of the table, if checked
+		table []byte
+		// Stops with an error, strictly
+		err bool
+	}{
+		{name: "arithmetic", code: `
+    mul -7 6 -> sp
+    add sp 2 -> result`, result: 0xFFD8},
+		{name: "div", code: "    div -7 2 -> result", result: 0xFFFD},
+		{name: "mod", code: "    mod -7 2 -> result", result: 0xFFFF},
+		{name: "div by zero", code: "    div 1 0 -> result", err: true},
+		{name: "logic", code: `
+    or $F0 $0F -> sp
+    and sp $3C -> sp
+    not sp -> result`, result: 0xFFC3},
+		{name: "branches", code: `
+    je 3 1 2 3 ?~end
+    jl -1 0 ?~end
+    jg 1 -1 ?~end
+    jz 0 ?~end
+    store result 1
+end:`, result: 1},
+		{name: "inc_chk", code: `
+loop:
+    inc_chk result 3 ?~loop`, result: 4},
+		{name: "dec_chk", code: `
+    store result 2
+loop:
+    dec_chk result -2 ?~loop`, result: 0xFFFD},
+		{name: "call", code: "    call double 21 -> result", result: 42},
+		{name: "locals", code: `
+    call three 1 -> result
+    new_line
+    call three 1 2 3 -> sp`, output: "1 7 0\n1 2 3", result: 1},
+		{name: "missing local", code: "    call bad 1 -> result", err: true},
+		{name: "stack", code: `
+    push 5
+    push 6
+    pull result
+    add result sp -> result`, result: 11},
+		{name: "tables", code: `
+    storew table 1 $1234
+    storeb table 0 9
+    loadw table 1 -> sp
+    loadb table 2 -> sp
+    add sp sp -> result`, result: 0x1234 + 0x12, table: []byte{9, 0, 0x12, 0x34}},
+		{name: "objects", code: `
+    insert_obj coin bag
+    get_parent coin -> result
+    print_obj result
+    get_child box -> sp ?end
+    print " and the box is empty"
+end:`, output: "bag and the box is empty", result: 3},
+		{name: "attributes", code: `
+    set_attr coin 4
+    clear_attr box 2
+    test_attr box 2 ?end
+    test_attr coin 4 ?~end
+    store result 1
+end:`, result: 1},
+		{name: "properties", code: `
+    put_prop box 10 $0304
+    get_prop box 10 -> sp
+    get_next_prop box 10 -> sp
+    get_prop coin 5 -> sp
+    add sp sp -> sp
+    add sp sp -> result`, result: 0x0304 + 5},
+		{name: "print", code: `
+    print "Hello "
+    print_paddr greeting
+    print_num -5
+    print_char 33
+    new_line`, output: "Hello Hi-5!\n"},
+		{name: "output stream 3", code: `
+    output_stream 3 table
+    print "abc"
+    output_stream -3
+    print "!"`, output: "!", table: []byte{0, 3, 'a', 'b', 'c'}},
+	} {
+		story, err := zasm.Assemble(fmt.Sprintf(prelude, test.code))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		zm, output, err := run(t, story)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil || !zm.Done {
+			t.Errorf("%s: error %v, done %v", test.name, err, zm.Done)
+			continue
+		}
+		if output != test.output {
+			t.Errorf("%s: output %q, want %q", test.name, output, test.output)
+		}
+		if result := zm.ReadGlobal(0x10); result != test.result {
+			t.Errorf("%s: result 0x%X, want 0x%X", test.name, result, test.result)
+		}
+		table := uint32(zm.ReadGlobal(0x11))
+		for i, b := range test.table {
+			if got := zm.LoadByte(table + uint32(i)); got != b {
+				t.Errorf("%s: table byte %d = 0x%X, want 0x%X", test.name, i, got, b)
+			}
+		}
+	}
+}
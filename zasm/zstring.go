@@ -0,0 +1,76 @@
+package zasm
+
+import "strings"
+
+var alphabets = []string{"abcdefghijklmnopqrstuvwxyz",
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	" \n0123456789.,!?_#'\"/\\-:()"}
+
+// Converts text to Z-characters using the default alphabet table.
+// Characters outside the alphabets are emitted as 10-bit ZSCII escapes.
+func zchars(txt string) []uint8 {
+	var zc []uint8
+	for i := 0; i < len(txt); i++ {
+		c := txt[i]
+		if c == ' ' {
+			zc = append(zc, 0)
+			continue
+		}
+		found := false
+		for a := 0; a < len(alphabets); a++ {
+			index := strings.IndexByte(alphabets[a], c)
+			// A2 position 0 is the escape, never a real character
+			if index < 0 || (a == 2 && index == 0) {
+				continue
+			}
+			if a != 0 {
+				zc = append(zc, uint8(a+3))
+			}
+			zc = append(zc, uint8(index+6))
+			found = true
+			break
+		}
+		if !found {
+			zc = append(zc, 5, 6, c>>5, c&0x1F)
+		}
+	}
+	return zc
+}
+
+// Packs Z-characters three to a word, setting the end bit on the last word
+func packZChars(zc []uint8) []byte {
+	for len(zc)%3 != 0 || len(zc) == 0 {
+		zc = append(zc, 5)
+	}
+	out := make([]byte, 0, len(zc)/3*2)
+	for i := 0; i < len(zc); i += 3 {
+		w := (uint16(zc[i]) << 10) | (uint16(zc[i+1]) << 5) | uint16(zc[i+2])
+		if i+3 >= len(zc) {
+			w |= 0x8000
+		}
+		out = append(out, uint8(w>>8), uint8(w))
+	}
+	return out
+}
+
+// EncodeString returns the Z-string encoding of txt.
+func EncodeString(txt string) []byte {
+	return packZChars(zchars(txt))
+}
+
+// EncodeWord returns the dictionary encoding of word: 6 Z-characters
+// in versions 1-3 and 9 in later versions, truncated or padded.
+func EncodeWord(word string, version uint8) []byte {
+	n := 6
+	if version > 3 {
+		n = 9
+	}
+	zc := zchars(strings.ToLower(word))
+	if len(zc) > n {
+		zc = zc[:n]
+	}
+	for len(zc) < n {
+		zc = append(zc, 5)
+	}
+	return packZChars(zc)
+}
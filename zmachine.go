@@ -3,28 +3,120 @@ package zmachine
 // based on: http://msinilo.pl/blog2/post/p1252/
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 )
 
 type ZMachine struct {
-	ip         uint32
-	header     ZHeader
-	buf        []uint8
+	ip     uint32
+	header ZHeader
+	// Story file image. Only static and high memory are read from it,
+	// so it's never modified and can be shared between clones.
+	buf []uint8
+	// Private copy of dynamic memory (everything below staticMemAddress)
+	dynMem     []uint8
 	stack      *ZStack
 	localFrame uint16
-	Done       bool
+	rng        ZRandomGenerator
+	config     Config
+	blorb      *Blorb
+	input      *bufio.Reader
+	output     io.Writer
+	// Where the save and restore instructions keep saved games
+	Storage ZStorage
+	// Turn limits for Run, none by default
+	Limits Limits
+	// Where WorldState looks, ActiveProfile() if nil
+	Profile *GameProfile
+	// If set, every instruction is logged there before it runs
+	Trace            io.Writer
+	instructionStart uint32
+	// Decode every instruction as it runs, as when debugging the decoder
+	DisableCache bool
+	// Run routines of static and high memory as compiled closures,
+	// except when tracing or profiling. Needs the cache.
+	CompileRoutines bool
+	// If set, counts instructions by opcode and routine
+	Profiler *Profiler
+	// Run by Run so far
+	instructions uint64
+	decoded      *instructionCache
+	step         *compiledStep
+	// Operands of the running instruction
+	operands [4]uint16
+	// Input comes from SendLine and SendKey instead of the input reader
+	stepping bool
+	waiting  InputRequest
+	Done     bool
+	// What to do when the game breaks the rules, stop by default
+	Policy ErrorPolicy
+	// Where POLICY_WARN logs, os.Stderr if nil
+	Warnings io.Writer
+	// Instructions already warned about
+	warned map[uint32]bool
+	// Tables selected by output_stream 3, innermost last
+	memoryStreams []memoryStream
+	// Deselected by output_stream -1
+	screenOff bool
+}
+
+// Player input is read from r (os.Stdin by default)
+func (zm *ZMachine) SetInput(r io.Reader) {
+	zm.input = bufio.NewReader(r)
+}
+
+// Game output goes to w (os.Stdout by default)
+func (zm *ZMachine) SetOutput(w io.Writer) {
+	zm.output = w
+}
+
+func (zm *ZMachine) print(s string) {
+	// Stream 3 takes the text from all the others
+	if n := len(zm.memoryStreams); n > 0 {
+		zm.memoryStreams[n-1].write(zm, s)
+		return
+	}
+	if zm.screenOff {
+		return
+	}
+	if zm.output == nil {
+		zm.output = os.Stdout
+	}
+	io.WriteString(zm.output, s)
+}
+
+// Reads a line of player input, without the line terminator
+func (zm *ZMachine) readLine() (string, error) {
+	if zm.input == nil {
+		zm.SetInput(os.Stdin)
+	}
+	line, err := zm.input.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (zm *ZMachine) IP() uint32 {
+	return zm.ip
+}
+
+func (zm *ZMachine) Stack() *ZStack {
+	return zm.stack
 }
 
 // Doesn't modify IP
 func (zm *ZMachine) PeekByte() uint8 {
-	return zm.buf[zm.ip]
+	return zm.GetUint8(zm.ip)
 }
 
 // Reads & moves to the next one (advances IP)
 func (zm *ZMachine) ReadByte() uint8 {
 	zm.ip++
-	return zm.buf[zm.ip-1]
+	return zm.GetUint8(zm.ip - 1)
 }
 
 // Reads 2 bytes and advances IP
@@ -34,44 +126,29 @@ func (zm *ZMachine) ReadUint16() uint16 {
 	return retVal
 }
 
-// We can only write to dynamic memory
-func (zm *ZMachine) IsSafeToWrite(address uint32) bool {
-	return address < zm.header.staticMemAddress
-}
-
-func (zm *ZMachine) GetUint16(offset uint32) uint16 {
-	return (uint16(zm.buf[offset]) << 8) | (uint16)(zm.buf[offset+1])
-}
-
-func (zm *ZMachine) SetUint16(offset uint32, v uint16) {
-	zm.buf[offset] = uint8(v >> 8)
-	zm.buf[offset+1] = uint8(v & 0xFF)
-}
-
 func (zm *ZMachine) ReadGlobal(x uint8) uint16 {
 	if x < 0x10 {
-		panic("Invalid global variable")
+		panic(zm.fault("Invalid global variable %d", x))
 	}
 
 	addr := PackedAddress(uint32(x) - 0x10)
-	ret := zm.GetUint16(zm.header.globalVarAddress + addr)
+	ret := zm.LoadWord(zm.header.globalVarAddress + addr)
 
 	return ret
 }
 
 func (zm *ZMachine) SetGlobal(x uint16, v uint16) {
 	if x < 0x10 {
-		panic("Invalid global variable")
+		panic(zm.fault("Invalid global variable %d", x))
 	}
 
 	addr := PackedAddress(uint32(x) - 0x10)
-	zm.SetUint16(zm.header.globalVarAddress+addr, v)
+	zm.StoreWord(zm.header.globalVarAddress+addr, v)
 }
 
 func (zm *ZMachine) GetObjectEntryAddress(objectIndex uint16) uint32 {
 	if objectIndex > MAX_OBJECT || objectIndex == 0 {
-		fmt.Printf("Index: %d\n", objectIndex)
-		panic("Invalid object index")
+		panic(zm.fault("Invalid object index %d", objectIndex))
 	}
 
 	// Convert from 1-based (0 = NULL = no object) to 0-based
@@ -84,18 +161,21 @@ func (zm *ZMachine) GetObjectEntryAddress(objectIndex uint16) uint32 {
 }
 
 func (zm *ZMachine) SetObjectProperty(objectIndex uint16, propertyId uint16, value uint16) {
+	if !zm.checkObject(objectIndex) {
+		return
+	}
 
 	objectEntryAddress := uint32(zm.GetObjectEntryAddress(objectIndex))
 
-	propertiesAddress := GetUint16(zm.buf, objectEntryAddress+7)
-	nameLength := uint16(zm.buf[propertiesAddress]) * 2 // in 2-byte words
+	propertiesAddress := zm.LoadWord(objectEntryAddress + 7)
+	nameLength := uint16(zm.LoadByte(uint32(propertiesAddress))) * 2 // in 2-byte words
 
 	// Find property
 	found := false
 	propData := uint32(propertiesAddress + nameLength + 1)
 
 	for !found {
-		propSize := zm.buf[propData]
+		propSize := zm.LoadByte(propData)
 		if propSize == 0 {
 			break
 		}
@@ -112,24 +192,26 @@ func (zm *ZMachine) SetObjectProperty(objectIndex uint16, propertyId uint16, val
 			found = true
 
 			if numBytes == 1 {
-				zm.buf[propData] = uint8(value & 0xFF)
-			} else if numBytes == 2 {
-				zm.SetUint16(propData, value)
+				zm.StoreByte(propData, uint8(value&0xFF))
 			} else {
-				panic("SetObjectProperty only supports 1/2 byte properties")
+				if numBytes > 2 {
+					// Its first word, when not strict
+					zm.violation(zm.fault("put_prop on property %d of %d bytes", propertyId, numBytes))
+				}
+				zm.StoreWord(propData, value)
 			}
 		}
 		propData += uint32(numBytes)
 	}
 	if !found {
-		panic("Property not found!")
+		zm.violation(zm.fault("Property %d not found on object %d", propertyId, objectIndex))
 	}
 }
 
 func (zm *ZMachine) GetFirstPropertyAddress(objectIndex uint16) uint16 {
 	objectEntryAddress := uint32(zm.GetObjectEntryAddress(objectIndex))
-	propertiesAddress := GetUint16(zm.buf, objectEntryAddress+7)
-	nameLength := uint16(zm.buf[propertiesAddress]) * 2 // in 2-byte words
+	propertiesAddress := zm.LoadWord(objectEntryAddress + 7)
+	nameLength := uint16(zm.LoadByte(uint32(propertiesAddress))) * 2 // in 2-byte words
 	propData := propertiesAddress + nameLength + 1
 
 	return propData
@@ -138,14 +220,18 @@ func (zm *ZMachine) GetFirstPropertyAddress(objectIndex uint16) uint16 {
 // Returns prop data address, number of property bytes
 // (0 if not found)
 func (zm *ZMachine) GetObjectPropertyInfo(objectIndex uint16, propertyId uint16) (uint16, uint16) {
+	if !zm.checkObject(objectIndex) {
+		return 0, 0
+	}
 
-	propData := zm.GetFirstPropertyAddress(objectIndex)
+	// Not wrapping around, a list without an end runs out of memory
+	propData := uint32(zm.GetFirstPropertyAddress(objectIndex))
 
 	// Find property
 	found := false
 
 	for !found {
-		propSize := zm.buf[propData]
+		propSize := zm.LoadByte(propData)
 		if propSize == 0 {
 			break
 		}
@@ -159,9 +245,9 @@ func (zm *ZMachine) GetObjectPropertyInfo(objectIndex uint16, propertyId uint16)
 
 		numBytes := uint16(propSize>>5) + 1
 		if propNo == propertyId {
-			return propData, numBytes
+			return uint16(propData), numBytes
 		}
-		propData += uint16(numBytes)
+		propData += uint32(numBytes)
 	}
 	return uint16(0), uint16(0)
 }
@@ -172,19 +258,23 @@ func (zm *ZMachine) GetObjectPropertyAddress(objectIndex uint16, propertyId uint
 }
 
 func (zm *ZMachine) GetNextObjectProperty(objectIndex uint16, propertyId uint16) uint16 {
+	if !zm.checkObject(objectIndex) {
+		return 0
+	}
 
 	nextPropSize := uint8(0)
 
 	// " if called with zero, it gives the first property number present."
 	if propertyId == 0 {
 		propData := zm.GetFirstPropertyAddress(objectIndex)
-		nextPropSize = zm.buf[propData]
+		nextPropSize = zm.LoadByte(uint32(propData))
 	} else {
 		propData, numBytes := zm.GetObjectPropertyInfo(objectIndex, propertyId)
 		if propData == 0 {
-			panic("GetNextObjectProperty - non existent property")
+			zm.violation(zm.fault("get_next_prop on missing property %d of object %d", propertyId, objectIndex))
+			return 0
 		}
-		nextPropSize = zm.buf[propData+numBytes]
+		nextPropSize = zm.LoadByte(uint32(propData + numBytes))
 	}
 	// "zero, indicating the end of the property list"
 	if nextPropSize == 0 {
@@ -195,6 +285,9 @@ func (zm *ZMachine) GetNextObjectProperty(objectIndex uint16, propertyId uint16)
 }
 
 func (zm *ZMachine) GetObjectProperty(objectIndex uint16, propertyId uint16) uint16 {
+	if !zm.checkObject(objectIndex) {
+		return 0
+	}
 
 	propData, numBytes := zm.GetObjectPropertyInfo(objectIndex, propertyId)
 	result := uint16(0)
@@ -205,11 +298,13 @@ func (zm *ZMachine) GetObjectProperty(objectIndex uint16, propertyId uint16) uin
 		DebugPrintf("Default prop %d = 0x%X\n", propertyId, result)
 	} else {
 		if numBytes == 1 {
-			result = uint16(zm.buf[propData])
-		} else if numBytes == 2 {
-			result = GetUint16(zm.buf, uint32(propData))
+			result = uint16(zm.LoadByte(uint32(propData)))
 		} else {
-			panic("GetObjectProperty only supports 1/2 byte properties")
+			if numBytes > 2 {
+				// Its first word, when not strict
+				zm.violation(zm.fault("get_prop on property %d of %d bytes", propertyId, numBytes))
+			}
+			result = zm.LoadWord(uint32(propData))
 		}
 	}
 
@@ -219,13 +314,13 @@ func (zm *ZMachine) GetObjectProperty(objectIndex uint16, propertyId uint16) uin
 // True if set
 func (zm *ZMachine) TestObjectAttr(objectIndex uint16, attribute uint16) bool {
 
-	if attribute > 31 {
-		panic("Attribute out of bounds")
+	if !zm.checkAttribute(objectIndex, attribute) {
+		return false
 	}
 
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
 
-	attribs := GetUint32(zm.buf, objectEntryAddress)
+	attribs := uint32(zm.LoadWord(objectEntryAddress))<<16 | uint32(zm.LoadWord(objectEntryAddress+2))
 	// 0: top bit
 	// 31: bottom bit
 	mask := uint32(1 << (31 - attribute))
@@ -235,79 +330,96 @@ func (zm *ZMachine) TestObjectAttr(objectIndex uint16, attribute uint16) bool {
 
 func (zm *ZMachine) SetObjectAttr(objectIndex uint16, attribute uint16) {
 
-	if attribute > 31 {
-		panic("Attribute out of bounds")
+	if !zm.checkAttribute(objectIndex, attribute) {
+		return
 	}
 
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
 	byteIndex := uint32(attribute >> 3)
 	shift := 7 - (attribute & 0x7)
 
-	zm.buf[objectEntryAddress+byteIndex] |= (1 << shift)
+	zm.StoreByte(objectEntryAddress+byteIndex, zm.LoadByte(objectEntryAddress+byteIndex)|(1<<shift))
 }
 
 func (zm *ZMachine) ClearObjectAttr(objectIndex uint16, attribute uint16) {
 
-	if attribute > 31 {
-		panic("Attribute out of bounds")
+	if !zm.checkAttribute(objectIndex, attribute) {
+		return
 	}
 
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
 	byteIndex := uint32(attribute >> 3)
 	shift := 7 - (attribute & 0x7)
 
-	zm.buf[objectEntryAddress+byteIndex] &= ^(1 << shift)
+	zm.StoreByte(objectEntryAddress+byteIndex, zm.LoadByte(objectEntryAddress+byteIndex)&^(1<<shift))
 }
 
 func (zm *ZMachine) IsDirectParent(childIndex uint16, parentIndex uint16) bool {
-
+	if !zm.checkObject(childIndex) {
+		return false
+	}
 	return zm.GetParentObject(childIndex) == parentIndex
 }
 
 func (zm *ZMachine) GetParentObject(objectIndex uint16) uint16 {
+	if !zm.checkObject(objectIndex) {
+		return NULL_OBJECT_INDEX
+	}
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
 
-	return uint16(zm.buf[objectEntryAddress+OBJECT_PARENT_INDEX])
+	return uint16(zm.LoadByte(objectEntryAddress + OBJECT_PARENT_INDEX))
 }
 
 // Unlink object from its parent
 func (zm *ZMachine) UnlinkObject(objectIndex uint16) {
+	if !zm.checkObject(objectIndex) {
+		return
+	}
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
-	currentParentIndex := uint16(zm.buf[objectEntryAddress+OBJECT_PARENT_INDEX])
+	currentParentIndex := uint16(zm.LoadByte(objectEntryAddress + OBJECT_PARENT_INDEX))
 
 	// Unlink from current parent first
 	if currentParentIndex != NULL_OBJECT_INDEX {
 		curParentAddress := zm.GetObjectEntryAddress(currentParentIndex)
 		// If we're the first child -> move to sibling
-		if uint16(zm.buf[curParentAddress+OBJECT_CHILD_INDEX]) == objectIndex {
-			zm.buf[curParentAddress+OBJECT_CHILD_INDEX] = zm.buf[objectEntryAddress+OBJECT_SIBLING_INDEX]
+		if uint16(zm.LoadByte(curParentAddress+OBJECT_CHILD_INDEX)) == objectIndex {
+			zm.StoreByte(curParentAddress+OBJECT_CHILD_INDEX, zm.LoadByte(objectEntryAddress+OBJECT_SIBLING_INDEX))
 		} else {
-			childIter := uint16(zm.buf[curParentAddress+OBJECT_CHILD_INDEX])
+			childIter := uint16(zm.LoadByte(curParentAddress + OBJECT_CHILD_INDEX))
 			prevChild := uint16(NULL_OBJECT_INDEX)
-			for childIter != objectIndex && childIter != NULL_OBJECT_INDEX {
+			for n := 0; childIter != objectIndex && childIter != NULL_OBJECT_INDEX; n++ {
+				if n > MAX_OBJECT {
+					zm.violation(zm.fault("Loop in the object tree"))
+					return
+				}
 				prevChild = childIter
 				childIter = zm.GetSibling(childIter)
 			}
 			// Sanity checks
 			if childIter == NULL_OBJECT_INDEX {
-				panic("Object not found on parent children list")
+				zm.violation(zm.fault("Object %d not found on parent children list", objectIndex))
+				return
 			}
 			if prevChild == NULL_OBJECT_INDEX {
-				panic("Corrupted data")
+				zm.violation(zm.fault("Corrupted object tree"))
+				return
 			}
 
 			prevSiblingAddress := zm.GetObjectEntryAddress(prevChild)
-			sibling := zm.buf[objectEntryAddress+OBJECT_SIBLING_INDEX]
-			zm.buf[prevSiblingAddress+OBJECT_SIBLING_INDEX] = sibling
+			sibling := zm.LoadByte(objectEntryAddress + OBJECT_SIBLING_INDEX)
+			zm.StoreByte(prevSiblingAddress+OBJECT_SIBLING_INDEX, sibling)
 		}
-		zm.buf[objectEntryAddress+OBJECT_PARENT_INDEX] = NULL_OBJECT_INDEX
+		zm.StoreByte(objectEntryAddress+OBJECT_PARENT_INDEX, NULL_OBJECT_INDEX)
 	}
 }
 
 func (zm *ZMachine) ReparentObject(objectIndex uint16, newParentIndex uint16) {
+	if !zm.checkObject(objectIndex) || !zm.checkObject(newParentIndex) {
+		return
+	}
 
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
-	currentParentIndex := uint16(zm.buf[objectEntryAddress+OBJECT_PARENT_INDEX])
+	currentParentIndex := uint16(zm.LoadByte(objectEntryAddress + OBJECT_PARENT_INDEX))
 
 	if currentParentIndex == newParentIndex {
 		return
@@ -317,39 +429,70 @@ func (zm *ZMachine) ReparentObject(objectIndex uint16, newParentIndex uint16) {
 
 	// Make the first child of our new parent
 	newParentAddress := zm.GetObjectEntryAddress(newParentIndex)
-	zm.buf[objectEntryAddress+OBJECT_SIBLING_INDEX] = zm.buf[newParentAddress+OBJECT_CHILD_INDEX]
-	zm.buf[newParentAddress+OBJECT_CHILD_INDEX] = uint8(objectIndex)
-	zm.buf[objectEntryAddress+OBJECT_PARENT_INDEX] = uint8(newParentIndex)
+	zm.StoreByte(objectEntryAddress+OBJECT_SIBLING_INDEX, zm.LoadByte(newParentAddress+OBJECT_CHILD_INDEX))
+	zm.StoreByte(newParentAddress+OBJECT_CHILD_INDEX, uint8(objectIndex))
+	zm.StoreByte(objectEntryAddress+OBJECT_PARENT_INDEX, uint8(newParentIndex))
 }
 
 func (zm *ZMachine) GetFirstChild(objectIndex uint16) uint16 {
+	if !zm.checkObject(objectIndex) {
+		return NULL_OBJECT_INDEX
+	}
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
 
-	return uint16(zm.buf[objectEntryAddress+OBJECT_CHILD_INDEX])
+	return uint16(zm.LoadByte(objectEntryAddress + OBJECT_CHILD_INDEX))
 }
 
 func (zm *ZMachine) GetSibling(objectIndex uint16) uint16 {
+	if !zm.checkObject(objectIndex) {
+		return NULL_OBJECT_INDEX
+	}
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
 
-	return uint16(zm.buf[objectEntryAddress+OBJECT_SIBLING_INDEX])
+	return uint16(zm.LoadByte(objectEntryAddress + OBJECT_SIBLING_INDEX))
 }
 
-func (zm *ZMachine) PrintObjectName(objectIndex uint16) {
+// The object table has no count: it ends where the first property
+// table starts
+func (zm *ZMachine) NumObjects() uint16 {
+	start := zm.header.objTableAddress + (31 * 2)
+	end := uint32(zm.LoadWord(start + 7))
+
+	n := uint16(0)
+	for addr := start; addr+OBJECT_ENTRY_SIZE <= end && n < MAX_OBJECT; addr += OBJECT_ENTRY_SIZE {
+		n++
+		if props := uint32(zm.LoadWord(addr + 7)); props < end {
+			end = props
+		}
+	}
+	return n
+}
+
+func (zm *ZMachine) GetObjectName(objectIndex uint16) string {
+	if !zm.checkObject(objectIndex) {
+		return ""
+	}
 	objectEntryAddress := zm.GetObjectEntryAddress(objectIndex)
-	propertiesAddress := uint32(GetUint16(zm.buf, objectEntryAddress+7))
-	zm.DecodeZString(propertiesAddress + 1)
+	propertiesAddress := uint32(zm.LoadWord(objectEntryAddress + 7))
+	name, _ := zm.ReadZString(propertiesAddress + 1)
+	return name
+}
+
+func (zm *ZMachine) PrintObjectName(objectIndex uint16) {
+	zm.print(zm.GetObjectName(objectIndex))
 }
 
 // Returns new value.
 func (zm *ZMachine) AddToVar(varType uint16, value int16) uint16 {
 	retValue := uint16(0)
 	if varType == 0 {
-		zm.stack.stack[zm.stack.top] += uint16(value)
-		retValue = zm.stack.GetTopItem()
+		// In place, the stack isn't popped
+		retValue = zm.popStack() + uint16(value)
+		zm.stack.Push(retValue)
 	} else if varType < 0x10 {
-		retValue = zm.stack.GetLocalVar((int)(varType - 1))
+		retValue = zm.readLocal(int(varType - 1))
 		retValue += uint16(value)
-		zm.stack.SetLocalVar(int(varType-1), retValue)
+		zm.writeLocal(int(varType-1), retValue)
 	} else {
 		retValue = zm.ReadGlobal(uint8(varType))
 		retValue += uint16(value)
@@ -364,33 +507,35 @@ func (zm *ZMachine) GetOperand(operandType byte) uint16 {
 
 	switch operandType {
 	case OPERAND_SMALL:
-		retValue = uint16(zm.buf[zm.ip])
+		retValue = uint16(zm.GetUint8(zm.ip))
 		zm.ip++
 	case OPERAND_VARIABLE:
-		varType := zm.buf[zm.ip]
-		// 0 = top of the stack
-		// 1 - 0xF = locals
-		// 0x10 - 0xFF = globals
-		if varType == 0 {
-			retValue = zm.stack.Pop()
-		} else if varType < 0x10 {
-			retValue = zm.stack.GetLocalVar((int)(varType - 1))
-		} else {
-			retValue = zm.ReadGlobal(varType)
-		}
+		retValue = zm.readVariable(zm.GetUint8(zm.ip))
 		zm.ip++
 	case OPERAND_LARGE:
-		retValue = GetUint16(zm.buf, zm.ip)
+		retValue = zm.GetUint16(zm.ip)
 		zm.ip += 2
 	case OPERAND_OMITTED:
 		return 0
 	default:
-		panic("Unknown operand type")
+		panic(zm.fault("Unknown operand type %d", operandType))
 	}
 
 	return retValue
 }
 
+func (zm *ZMachine) readVariable(varType uint8) uint16 {
+	// 0 = top of the stack
+	// 1 - 0xF = locals
+	// 0x10 - 0xFF = globals
+	if varType == 0 {
+		return zm.popStack()
+	} else if varType < 0x10 {
+		return zm.readLocal(int(varType - 1))
+	}
+	return zm.ReadGlobal(varType)
+}
+
 func (zm *ZMachine) GetOperands(opTypesByte uint8, operandValues []uint16) uint16 {
 	numOperands := uint16(0)
 	var shift uint8
@@ -418,7 +563,7 @@ func (zm *ZMachine) StoreAtLocation(storeLocation uint16, v uint16) {
 	if storeLocation == 0 {
 		zm.stack.Push(v)
 	} else if storeLocation < 0x10 {
-		zm.stack.SetLocalVar((int)(storeLocation-1), v)
+		zm.writeLocal(int(storeLocation-1), v)
 	} else {
 		zm.SetGlobal(storeLocation, v)
 	}
@@ -443,9 +588,20 @@ func (zm *ZMachine) InterpretVARInstruction() {
 	// "A value of 0 means a small constant and 1 means a variable."
 	opTypesByte := zm.ReadByte()
 
-	opValues := make([]uint16, 4)
+	opValues := zm.operands[:]
+	for i := range opValues {
+		opValues[i] = 0
+	}
 	numOperands := zm.GetOperands(opTypesByte, opValues)
 
+	if zm.Trace != nil {
+		if twoOp {
+			zm.traceInstruction(ZFunctionNames_2OP, instruction, opValues[:numOperands])
+		} else {
+			zm.traceInstruction(ZFunctionNames_VAR, instruction, opValues[:numOperands])
+		}
+	}
+
 	if twoOp {
 		fn := ZFunctions_2OP[instruction]
 		fn(zm, opValues, numOperands)
@@ -466,9 +622,15 @@ func (zm *ZMachine) InterpretShortInstruction() {
 	if opType != OPERAND_OMITTED {
 		opValue := zm.GetOperand(opType)
 
+		if zm.Trace != nil {
+			zm.traceInstruction(ZFunctionNames_1OP, instruction, []uint16{opValue})
+		}
 		fn := ZFunctions_1OP[instruction]
 		fn(zm, opValue)
 	} else {
+		if zm.Trace != nil {
+			zm.traceInstruction(ZFunctionNames_0P, instruction, nil)
+		}
 		fn := ZFunctions_0P[instruction]
 		fn(zm)
 	}
@@ -487,21 +649,57 @@ func (zm *ZMachine) InterpretLongInstruction() {
 	operandType0 := ((opcode & 0x40) >> 6) + 1
 	operandType1 := ((opcode & 0x20) >> 5) + 1
 
-	opValues := make([]uint16, 2)
+	opValues := zm.operands[:2]
 	opValue0 := zm.GetOperand(operandType0)
 	opValue1 := zm.GetOperand(operandType1)
 
 	opValues[0] = opValue0
 	opValues[1] = opValue1
 
+	if zm.Trace != nil {
+		zm.traceInstruction(ZFunctionNames_2OP, instruction, opValues)
+	}
+
 	fn := ZFunctions_2OP[instruction]
 	fn(zm, opValues, 2)
 }
 
+func (zm *ZMachine) traceInstruction(names []string, instruction uint8, args []uint16) {
+	name := "unknown"
+	if int(instruction) < len(names) {
+		name = names[instruction]
+	}
+	fmt.Fprintf(zm.Trace, "0x%05X: %s", zm.instructionStart, name)
+	for _, arg := range args {
+		fmt.Fprintf(zm.Trace, " 0x%X", arg)
+	}
+	fmt.Fprintln(zm.Trace)
+}
+
 func (zm *ZMachine) InterpretInstruction() {
+	if zm.Profiler != nil {
+		zm.profileInstruction()
+	} else {
+		zm.interpretInstruction()
+	}
+}
+
+func (zm *ZMachine) interpretInstruction() {
 	opcode := zm.PeekByte()
 
 	DebugPrintf("IP: 0x%X - opcode: 0x%X\n", zm.ip, opcode)
+	zm.instructionStart = zm.ip
+
+	// Dynamic memory may be rewritten, everything above is decoded once
+	if zm.ip >= zm.header.staticMemAddress && !zm.DisableCache {
+		if zm.CompileRoutines && zm.Trace == nil && zm.Profiler == nil {
+			zm.runCompiled()
+		} else {
+			zm.runDecoded(zm.cachedInstruction(zm.ip))
+		}
+		return
+	}
+
 	// Form is stored in top 2 bits
 	// "If the top two bits of the opcode are $$11 the form is variable; if $$10, the form is short.
 	// If the opcode is 190 ($BE in hexadecimal) and the version is 5 or later, the form is "extended".
@@ -585,22 +783,64 @@ func (zm *ZMachine) Initialize(buffer []uint8, header ZHeader) {
 	zm.ip = uint32(header.ip)
 	zm.stack = NewStack()
 
+	zm.dynMem = make([]uint8, header.staticMemAddress)
+	copy(zm.dynMem, buffer)
+	zm.decoded = newInstructionCache(header.staticMemAddress, len(buffer))
+
+	zm.rng.Reseed(0)
+	zm.Configure(DefaultConfig())
+
 	//zm.TestDictionary()
 }
 
+// Resources of the Blorb file the story was loaded from, nil otherwise
+func (zm *ZMachine) Blorb() *Blorb {
+	return zm.blorb
+}
+
+// Clone returns an independent copy of the running machine.
+// Dynamic memory, the stack and the random generator state are
+// duplicated, the story image (static and high memory) and its decoded
+// instructions are shared. The clone has no input, output, Trace or
+// Profiler: like a newly loaded machine it uses os.Stdin and os.Stdout
+// until given others. A MemoryStorage is copied, other Storage, the
+// Profile and Warnings are shared with the original.
+func (zm *ZMachine) Clone() *ZMachine {
+	clone := *zm
+	clone.dynMem = make([]uint8, len(zm.dynMem))
+	copy(clone.dynMem, zm.dynMem)
+	clone.stack = zm.stack.Clone()
+	clone.input = nil
+	clone.output = nil
+	clone.Trace = nil
+	clone.Profiler = nil
+	if storage, ok := zm.Storage.(*MemoryStorage); ok {
+		clone.Storage = storage.Clone()
+	}
+	clone.memoryStreams = append([]memoryStream(nil), zm.memoryStreams...)
+	if len(zm.warned) > 0 {
+		clone.warned = make(map[uint32]bool, len(zm.warned))
+		for ip := range zm.warned {
+			clone.warned[ip] = true
+		}
+	}
+
+	return &clone
+}
+
 // Return DICT_NOT_FOUND (= 0) if not found
 // Address in dictionary otherwise
 func (zm *ZMachine) FindInDictionary(str string) uint16 {
 
-	numSeparators := uint32(zm.buf[zm.header.dictAddress])
-	entryLength := uint16(zm.buf[zm.header.dictAddress+1+numSeparators])
-	numEntries := GetUint16(zm.buf, zm.header.dictAddress+1+numSeparators+1)
+	numSeparators := uint32(zm.LoadByte(zm.header.dictAddress))
+	entryLength := uint16(zm.LoadByte(zm.header.dictAddress + 1 + numSeparators))
+	numEntries := zm.LoadWord(zm.header.dictAddress + 1 + numSeparators + 1)
 
 	entriesAddress := zm.header.dictAddress + 1 + numSeparators + 1 + 2
 
 	// Dictionary entries are sorted, so we can use binary search
-	lowerBound := uint16(0)
-	upperBound := numEntries - 1
+	lowerBound := 0
+	upperBound := int(numEntries) - 1
 
 	encodedText := zm.EncodeText(str)
 
@@ -608,14 +848,15 @@ func (zm *ZMachine) FindInDictionary(str string) uint16 {
 	for lowerBound <= upperBound {
 
 		currentIndex := lowerBound + (upperBound-lowerBound)/2
-		dictValue := GetUint32(zm.buf, entriesAddress+uint32(currentIndex*entryLength))
+		entry := entriesAddress + uint32(currentIndex)*uint32(entryLength)
+		dictValue := uint32(zm.LoadWord(entry))<<16 | uint32(zm.LoadWord(entry+2))
 
 		if encodedText < dictValue {
 			upperBound = currentIndex - 1
 		} else if encodedText > dictValue {
 			lowerBound = currentIndex + 1
 		} else {
-			foundAddress = uint16(entriesAddress + uint32(currentIndex*entryLength))
+			foundAddress = uint16(entriesAddress + uint32(currentIndex)*uint32(entryLength))
 			break
 		}
 	}
@@ -625,18 +866,33 @@ func (zm *ZMachine) FindInDictionary(str string) uint16 {
 
 func (zm *ZMachine) GetPropertyDefault(propertyIndex uint16) uint16 {
 	if propertyIndex < 1 || propertyIndex > 31 {
-		panic("Invalid propertyIndex")
+		zm.violation(zm.fault("Invalid property %d", propertyIndex))
+		return 0
 	}
 
 	// 1-based -> 0-based
 	propertyIndex--
-	return GetUint16(zm.buf, zm.header.objTableAddress+uint32(propertyIndex*2))
+	return zm.LoadWord(zm.header.objTableAddress + uint32(propertyIndex*2))
 }
 
-// V3 only
+// Prints the string at startOffset
 // Returns offset pointing just after the string data
 func (zm *ZMachine) DecodeZString(startOffset uint32) uint32 {
+	text, next := zm.ReadZString(startOffset)
+	zm.print(text)
+
+	return next
+}
+
+// V3 only
+// Returns decoded string and offset pointing just after the string data
+func (zm *ZMachine) ReadZString(startOffset uint32) (string, uint32) {
+	return zm.readZString(startOffset, false)
+}
 
+func (zm *ZMachine) readZString(startOffset uint32, inAbbreviation bool) (string, uint32) {
+
+	var text strings.Builder
 	done := false
 	zchars := []uint8{}
 
@@ -647,7 +903,7 @@ func (zm *ZMachine) DecodeZString(startOffset uint32) uint32 {
 		//7    6 5 4 3 2  1 0   7 6 5  4 3 2 1 0
 		//bit  --first--  --second---  --third--
 
-		w16 := GetUint16(zm.buf, i)
+		w16 := zm.GetUint16(i)
 
 		done = (w16 & 0x8000) != 0
 		zchars = append(zchars, uint8((w16>>10)&0x1F), uint8((w16>>5)&0x1F), uint8(w16&0x1F))
@@ -662,12 +918,23 @@ func (zm *ZMachine) DecodeZString(startOffset uint32) uint32 {
 
 		// Abbreviation
 		if zc > 0 && zc < 4 {
+			// An incomplete construction at the end of a string is ignored
+			if i+1 >= len(zchars) {
+				break
+			}
+			// Abbreviations can't use abbreviations, which could loop forever
+			if inAbbreviation {
+				zm.violation(zm.fault("Abbreviation inside an abbreviation at 0x%X", startOffset))
+				i++
+				continue
+			}
 			abbrevIndex := zchars[i+1]
 
 			// "If z is the first Z-character (1, 2 or 3) and x the subsequent one,
 			// then the interpreter must look up entry 32(z-1)+x in the abbreviations table"
-			abbrevAddress := GetUint16(zm.buf, zm.header.abbreviationTable+uint32(32*(zc-1)+abbrevIndex)*2)
-			zm.DecodeZString(PackedAddress(uint32(abbrevAddress)))
+			abbrevAddress := zm.GetUint16(zm.header.abbreviationTable + uint32(32*(zc-1)+abbrevIndex)*2)
+			abbrev, _ := zm.readZString(PackedAddress(uint32(abbrevAddress)), true)
+			text.WriteString(abbrev)
 
 			alphabetType = 0
 			i++
@@ -684,9 +951,12 @@ func (zm *ZMachine) DecodeZString(startOffset uint32) uint32 {
 		// Z-character 6 from A2 means that the two subsequent Z-characters specify a ten-bit ZSCII character code:
 		// the next Z-character gives the top 5 bits and the one after the bottom 5.
 		if alphabetType == 2 && zc == 6 {
+			if i+2 >= len(zchars) {
+				break
+			}
 
 			zc10 := (uint16(zchars[i+1]) << 5) | uint16(zchars[i+2])
-			PrintZChar(zc10)
+			text.WriteString(ZSCIIString(zc10))
 
 			i += 2
 
@@ -695,15 +965,19 @@ func (zm *ZMachine) DecodeZString(startOffset uint32) uint32 {
 		}
 
 		if zc == 0 {
-			fmt.Printf(" ")
+			text.WriteByte(' ')
 		} else {
 			// If we're here zc >= 6. Alphabet tables are indexed starting at 6
 			aindex := zc - 6
-			fmt.Printf("%c", alphabets[alphabetType][aindex])
+			text.WriteByte(alphabets[alphabetType][aindex])
 		}
 
 		alphabetType = 0
 	}
 
-	return i
+	return text.String(), i
+}
+
+func (zm *ZMachine) PrintZChar(ch uint16) {
+	zm.print(ZSCIIString(ch))
 }
@@ -1,9 +1,16 @@
 package zmachine
 
+// Stack faults have no IP, recoverError gives them the instruction's
+func stackFault(msg string) *RuntimeError {
+	return &RuntimeError{Msg: msg}
+}
+
 type ZStack struct {
 	stack      []uint16
 	top        int
 	localFrame int
+	// Of the running routine, its evaluation stack starts below them
+	numLocals int
 }
 
 func NewStack() *ZStack {
@@ -15,9 +22,20 @@ func NewStack() *ZStack {
 	return s
 }
 
+func (s *ZStack) Clone() *ZStack {
+	c := new(ZStack)
+	c.stack = make([]uint16, MAX_STACK)
+	copy(c.stack[s.top:], s.stack[s.top:])
+	c.top = s.top
+	c.localFrame = s.localFrame
+	c.numLocals = s.numLocals
+
+	return c
+}
+
 func (s *ZStack) Push(value uint16) {
 	if s.top == 0 {
-		panic("Stack overflow")
+		panic(stackFault("Stack overflow"))
 	}
 	s.top--
 	s.stack[s.top] = value
@@ -25,7 +43,7 @@ func (s *ZStack) Push(value uint16) {
 
 func (s *ZStack) Pop() uint16 {
 	if s.top == MAX_STACK {
-		panic("Trying to pop from empty stack")
+		panic(stackFault("Trying to pop from empty stack"))
 	}
 	retValue := s.stack[s.top]
 
@@ -35,18 +53,29 @@ func (s *ZStack) Pop() uint16 {
 
 func (s *ZStack) Reset(newTop int) {
 	if newTop > MAX_STACK || newTop < 0 {
-		panic("Invalid stack top value")
+		panic(stackFault("Invalid stack top value"))
 	}
 	s.top = newTop
 }
 
 func (s *ZStack) GetTopItem() uint16 {
+	if s.top == MAX_STACK {
+		panic(stackFault("Trying to read from empty stack"))
+	}
 	return s.stack[s.top]
 }
 
 func (s *ZStack) SaveFrame() {
 	s.Push(uint16(s.localFrame))
+	s.Push(uint16(s.numLocals))
 	s.localFrame = s.top
+	s.numLocals = 0
+}
+
+// Empty for the running routine: only its locals and the frames of its
+// callers are left
+func (s *ZStack) IsEmpty() bool {
+	return s.top >= s.localFrame-s.numLocals
 }
 
 // Returns caller address (where to return to)
@@ -55,7 +84,12 @@ func (s *ZStack) RestoreFrame() uint32 {
 	// Discard local frame
 	s.top = s.localFrame
 	// Restore previous frame
+	s.numLocals = int(s.Pop())
 	s.localFrame = int(s.Pop())
+	// A restored game may have put something else there
+	if s.numLocals > 15 || s.localFrame > MAX_STACK || s.localFrame-s.numLocals < s.top+2 {
+		panic(stackFault("Corrupted stack frame"))
+	}
 
 	retLo := s.Pop()
 	retHi := s.Pop()
@@ -65,10 +99,10 @@ func (s *ZStack) RestoreFrame() uint32 {
 
 func (s *ZStack) ValidateLocalVarIndex(localVarIndex int) {
 	if localVarIndex > 0xF {
-		panic("Local var index out of bounds")
+		panic(stackFault("Local var index out of bounds"))
 	}
-	if s.localFrame < localVarIndex {
-		panic("Stack underflow")
+	if s.localFrame <= localVarIndex {
+		panic(stackFault("Stack underflow"))
 	}
 }
 func (s *ZStack) GetLocalVar(localVarIndex int) uint16 {
@@ -84,6 +118,11 @@ func (s *ZStack) SetLocalVar(localVarIndex int, value uint16) {
 	s.stack[stackIndex] = value
 }
 
+// Stack values, top first
+func (s *ZStack) Contents() []uint16 {
+	return s.stack[s.top:]
+}
+
 func (s *ZStack) Dump() {
 	DebugPrintf("Top = %d, local frame = %d\n", s.top, s.localFrame)
 